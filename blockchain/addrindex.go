@@ -0,0 +1,237 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"github.com/dgraph-io/badger"
+	"github.com/nd-sin/blockchain/wallet"
+	"log"
+)
+
+var (
+	// addrIndexPrefix keys the transactions of every address by public key
+	// hash, block height and position in the block, so the entries of an
+	// address sort oldest first
+	addrIndexPrefix = []byte("ah-")
+	// addrIndexKey marks databases whose address index is complete, the
+	// index is kept up to date from then on
+	addrIndexKey = []byte("addrindex")
+)
+
+// AddressTx is what a best chain transaction did to an address, the value of
+// its outputs paying the address and of the outputs of the address it spent
+type AddressTx struct {
+	TxID      []byte
+	BlockHash []byte
+	Height    int
+	Received  int
+	Spent     int
+}
+
+// Net is the balance change of the address
+func (atx AddressTx) Net() int {
+	return atx.Received - atx.Spent
+}
+
+func (atx AddressTx) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(atx)
+	if err != nil {
+		log.Panic(err)
+	}
+	return res.Bytes()
+}
+
+func DeserializeAddressTx(data []byte) (*AddressTx, error) {
+	var atx AddressTx
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&atx); err != nil {
+		return nil, err
+	}
+	return &atx, nil
+}
+
+func addressPrefix(pubKeyHash []byte) []byte {
+	return append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+}
+
+func addressKey(pubKeyHash []byte, height, position int) []byte {
+	var suffix [12]byte
+	binary.BigEndian.PutUint64(suffix[:8], uint64(height))
+	binary.BigEndian.PutUint32(suffix[8:], uint32(position))
+	return append(addressPrefix(pubKeyHash), suffix[:]...)
+}
+
+func hasAddrIndex(db *badger.DB) (bool, error) {
+	err := db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(addrIndexKey)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// indexAddresses records the addresses a block pays and spends from, spent
+// lists the outputs its inputs spent in the order of BlockUndo
+func indexAddresses(txn *badger.Txn, block *Block, spent []SpentOutput) error {
+	for i, tx := range block.Transactions {
+		activity := make(map[string]*AddressTx)
+		touch := func(pubKeyHash []byte) *AddressTx {
+			atx, ok := activity[string(pubKeyHash)]
+			if !ok {
+				atx = &AddressTx{tx.ID, block.Hash, block.Height, 0, 0}
+				activity[string(pubKeyHash)] = atx
+			}
+			return atx
+		}
+		for _, out := range tx.Outputs {
+			touch(out.PubKeyHash).Received += out.Value
+		}
+		if !tx.IsCoinbase() {
+			if len(spent) < len(tx.Inputs) {
+				return ErrNoUndoData
+			}
+			for _, so := range spent[:len(tx.Inputs)] {
+				touch(so.Output.PubKeyHash).Spent += so.Output.Value
+			}
+			spent = spent[len(tx.Inputs):]
+		}
+		for pubKeyHash, atx := range activity {
+			if err := txn.Set(addressKey([]byte(pubKeyHash), block.Height, i), atx.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unindexAddresses forgets the entries of a block, the addresses it spent
+// from are found from the public keys of its inputs
+func unindexAddresses(txn *badger.Txn, block *Block) error {
+	for i, tx := range block.Transactions {
+		for _, out := range tx.Outputs {
+			if err := txn.Delete(addressKey(out.PubKeyHash, block.Height, i)); err != nil {
+				return err
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if err := txn.Delete(addressKey(wallet.PublicKeyHash(in.PubKey), block.Height, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddrIndexed tells whether AddressHistory can be used
+func (chain *Blockchain) AddrIndexed() bool {
+	return chain.addrIndex
+}
+
+// ReindexAddresses builds the address index of the best chain from scratch
+// and keeps it up to date from then on
+func (chain *Blockchain) ReindexAddresses() error {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(addrIndexKey)
+	})
+	if err != nil {
+		return err
+	}
+	chain.addrIndex = false
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.DeleteByPrefix(addrIndexPrefix); err != nil {
+		return err
+	}
+	// unspent follows the outputs left after each block to value the inputs
+	// of the next ones
+	unspent := make(map[string]TxOutput)
+	iter := chain.ForwardIterator(0)
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			break
+		}
+		var spent []SpentOutput
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					key := outpoint(in.ID, in.Out)
+					out, ok := unspent[key]
+					if !ok {
+						return ErrOutputSpent
+					}
+					delete(unspent, key)
+					spent = append(spent, SpentOutput{in.ID, in.Out, out})
+				}
+			}
+			for i, out := range tx.Outputs {
+				unspent[outpoint(tx.ID, i)] = out
+			}
+		}
+		err = chain.Database.Update(func(txn *badger.Txn) error {
+			return indexAddresses(txn, block, spent)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(addrIndexKey, []byte{1})
+	})
+	if err != nil {
+		return err
+	}
+	chain.addrIndex = true
+	return nil
+}
+
+// AddressHistory pages through the transactions of an address newest first,
+// skipping skip entries and returning up to count of them along with the
+// total number of entries. It fails with ErrNoAddressIndex until the address
+// index is built
+func (chain *Blockchain) AddressHistory(pubKeyHash []byte, skip, count int) ([]AddressTx, int, error) {
+	if !chain.addrIndex {
+		return nil, 0, ErrNoAddressIndex
+	}
+	var history []AddressTx
+	total := 0
+	prefix := addressPrefix(pubKeyHash)
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		last := append(append([]byte{}, prefix...), bytes.Repeat([]byte{0xff}, 12)...)
+		for it.Seek(last); it.ValidForPrefix(prefix); it.Next() {
+			total++
+			if total <= skip || len(history) >= count {
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			atx, err := DeserializeAddressTx(value)
+			if err != nil {
+				return err
+			}
+			history = append(history, *atx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return history, total, nil
+}
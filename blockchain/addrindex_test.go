@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+func checkHistory(t *testing.T, chain *Blockchain, w *wallet.Wallet, want ...AddressTx) {
+	t.Helper()
+	history, total, err := chain.AddressHistory(wallet.PublicKeyHash(w.PublicKey), 0, len(want)+5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != len(want) || len(history) != len(want) || (len(want) > 0 && !reflect.DeepEqual(history, want)) {
+		t.Fatalf("history of %s is %+v of %d, want %+v", w.NetworkAddress(RegTestParams.AddressVersion), history, total, want)
+	}
+}
+
+// received is the entry of a transaction paying value to an address
+func received(tx *Transaction, block *Block, value int) AddressTx {
+	return AddressTx{tx.ID, block.Hash, block.Height, value, 0}
+}
+
+// TestAddrIndexFollowsReorgs switches to a fork and back to a longer main
+// chain, and rebuilds the index in between
+func TestAddrIndexFollowsReorgs(t *testing.T) {
+	chain, w, a, b, main, fork := forkedChain(t, Options{AddrIndex: true})
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisEntry := received(genesis.Transactions[0], genesis, 100)
+	moved := main.Transactions[1]
+	checkHistory(t, chain, w, AddressTx{moved.ID, main.Hash, 1, 0, 100}, genesisEntry)
+	checkHistory(t, chain, a, received(moved, main, 100), received(main.Transactions[0], main, 100))
+	checkHistory(t, chain, b)
+	page, total, err := chain.AddressHistory(wallet.PublicKeyHash(a.PublicKey), 1, 1)
+	if err != nil || total != 2 || len(page) != 1 || !reflect.DeepEqual(page[0], received(main.Transactions[0], main, 100)) {
+		t.Fatalf("second page of a is %+v of %d, error %v", page, total, err)
+	}
+
+	fork2 := mineOn(t, chain, fork.Hash, b)
+	accept(t, chain, fork2)
+	for i := 0; i < 2; i++ {
+		checkHistory(t, chain, w, genesisEntry)
+		checkHistory(t, chain, a)
+		checkHistory(t, chain, b, received(fork2.Transactions[0], fork2, 100), received(fork.Transactions[0], fork, 100))
+		if err := chain.ReindexAddresses(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	main2 := mineOn(t, chain, main.Hash, a)
+	accept(t, chain, main2)
+	main3 := mineOn(t, chain, main2.Hash, a)
+	accept(t, chain, main3)
+	checkHistory(t, chain, w, AddressTx{moved.ID, main.Hash, 1, 0, 100}, genesisEntry)
+	checkHistory(t, chain, a,
+		received(main3.Transactions[0], main3, 100), received(main2.Transactions[0], main2, 100),
+		received(moved, main, 100), received(main.Transactions[0], main, 100))
+	checkHistory(t, chain, b)
+}
+
+// TestAddrIndexRejectsLongKeyHash pays a key hash extending the one of an
+// address, the prefix scan of the address would list it if it were stored
+func TestAddrIndexRejectsLongKeyHash(t *testing.T) {
+	chain, w := newTestChain(t, Options{AddrIndex: true})
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := append(append([]byte{}, wallet.PublicKeyHash(w.PublicKey)...), 0)
+	tx := Transaction{nil, []TxInput{{genesis.Transactions[0].ID, 0, nil, w.PublicKey}}, []TxOutput{{100, long}}}
+	tx.ID = tx.Hash()
+	if err := chain.SignTransaction(&tx, w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.AcceptBlock(mineOn(t, chain, genesis.Hash, w, &tx)); !errors.Is(err, ErrBadTransaction) {
+		t.Fatalf("AcceptBlock returned %v, want %v", err, ErrBadTransaction)
+	}
+	checkHistory(t, chain, w, received(genesis.Transactions[0], genesis, 100))
+}
@@ -2,39 +2,70 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/gob"
 	"log"
+	"time"
 )
 
+const BlockVersion = 1
+
+// BlockHeader holds everything the proof of work commits to, the
+// transactions are covered through MerkleRoot
+type BlockHeader struct {
+	Version    int
+	PrevHash   []byte
+	MerkleRoot []byte
+	Timestamp  int64
+	Bits       int
+	Nonce      int
+	Height     int
+}
+
 type Block struct {
+	BlockHeader
 	Hash         []byte
 	Transactions []*Transaction
-	PrevHash     []byte
-	Nonce        int
+	// Signer and Signature seal proof of authority blocks
+	Signer    []byte
+	Signature []byte
 }
 
-func (b *Block) HashTransaction() []byte {
-	var txHashes [][]byte
-	var txHash [32]byte
+func (b *Block) MerkleTree() *MerkleTree {
+	var txIDs [][]byte
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.ID)
+		txIDs = append(txIDs, tx.ID)
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
-	return txHash[:]
+	return NewMerkleTree(txIDs)
+}
+
+// HashTransaction is the merkle root of the block's transaction IDs
+func (b *Block) HashTransaction() []byte {
+	return b.MerkleTree().Root()
+}
+
+func (b *Block) MerkleProof(txID []byte) (*MerkleProof, error) {
+	return b.MerkleTree().Proof(txID)
 }
 
-func CreateBlock(txs []*Transaction, prevHash []byte) *Block {
-	block := &Block{[]byte{}, txs, prevHash, 0}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
-	block.Hash = hash[:]
-	block.Nonce = nonce
+// NewBlock builds an unsealed block, Seal must find its nonce before it is
+// valid
+func NewBlock(txs []*Transaction, prevHash []byte, height, bits int) *Block {
+	header := BlockHeader{BlockVersion, prevHash, nil, time.Now().Unix(), bits, 0, height}
+	block := &Block{header, []byte{}, txs, nil, nil}
+	block.MerkleRoot = block.HashTransaction()
 	return block
 }
 
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{})
+// Seal mines the block, setting its nonce and hash
+func (b *Block) Seal(ctx context.Context, opts MineOptions) error {
+	nonce, hash, err := NewProof(b).Mine(ctx, opts)
+	if err != nil {
+		return err
+	}
+	b.Hash = hash
+	b.Nonce = nonce
+	return nil
 }
 
 func (b *Block) Serialize() []byte {
@@ -47,12 +78,12 @@ func (b *Block) Serialize() []byte {
 	return res.Bytes()
 }
 
-func Deserialize(data []byte) *Block {
+func Deserialize(data []byte) (*Block, error) {
 	var block Block
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&block)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return &block
+	return &block, nil
 }
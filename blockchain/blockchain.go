@@ -2,25 +2,30 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"github.com/dgraph-io/badger"
-	"log"
+	"io/ioutil"
 	"os"
-	"runtime"
+	"path/filepath"
 )
 
 const (
-	dbPath      = "./tmp/blocks"
-	dbFile      = "./tmp/blocks/MANIFEST"
 	genesisData = "First transaction from Genesis"
 )
 
 type Blockchain struct {
-	LastHash []byte
-	Database *badger.DB
+	LastHash  []byte
+	Database  *badger.DB
+	Options   Options
+	params    *NetworkParams
+	engine    Consensus
+	events    *EventBus
+	txIndex   bool
+	addrIndex bool
+	tempDir   string
 }
 
 type BlockchainIterator struct {
@@ -28,102 +33,344 @@ type BlockchainIterator struct {
 	Database    *badger.DB
 }
 
-func DBExists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+func DBExists(opts Options) bool {
+	if opts.InMemory {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(opts.BlocksDir(), "MANIFEST")); os.IsNotExist(err) {
 		return false
 	}
 	return true
 }
 
-func ConnectDB() *badger.DB {
-	opts := badger.DefaultOptions(dbPath)
-	db, err := badger.Open(opts)
-	if err != nil {
-		log.Panic(err)
+// ConnectDB opens the database of the network, creating its directory
+func ConnectDB(opts Options) (*badger.DB, error) {
+	if err := os.MkdirAll(opts.BlocksDir(), 0700); err != nil {
+		return nil, err
 	}
-	return db
+	return badger.Open(opts.badgerOptions(opts.BlocksDir()))
 }
 
-func InitBlockchain(address string) *Blockchain {
+func InitBlockchain(opts Options, address string) (*Blockchain, error) {
 	var lastHash []byte
-	if DBExists() {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
-	}
-	db := ConnectDB()
-	err := db.Update(func(txn *badger.Txn) error {
-		cbTx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbTx)
+	params, err := opts.NetworkParams()
+	if err != nil {
+		return nil, err
+	}
+	if DBExists(opts) {
+		return nil, ErrChainExists
+	}
+	cbTx, err := CoinbaseTx(address, genesisData, params.Subsidy(0), params)
+	if err != nil {
+		return nil, err
+	}
+	var db *badger.DB
+	var tempDir string
+	if opts.InMemory {
+		tempDir, err = ioutil.TempDir("", "blockchain-")
+		if err != nil {
+			return nil, err
+		}
+		db, err = badger.Open(opts.badgerOptions(tempDir))
+	} else {
+		db, err = ConnectDB(opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	engine := opts.Consensus
+	if engine == nil {
+		engine = ProofOfWorkEngine{}
+	}
+	chain := &Blockchain{nil, db, opts, params, engine, NewEventBus(), opts.TxIndex, opts.AddrIndex, tempDir}
+	genesis := NewBlock([]*Transaction{cbTx}, []byte{}, 0, 0)
+	err = engine.Prepare(chain, &genesis.BlockHeader, nil)
+	if err == nil {
+		err = engine.Seal(context.Background(), genesis, MineOptions{})
+	}
+	if err != nil {
+		chain.Close()
+		return nil, err
+	}
+	err = db.Update(func(txn *badger.Txn) error {
 		fmt.Println("Genesis created")
+		if err := txn.Set(consensusKey, consensusConfig(engine).Serialize()); err != nil {
+			return err
+		}
 		err := txn.Set(genesis.Hash, genesis.Serialize())
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		bi := chain.newBlockIndex(genesis, nil)
+		if err := txn.Set(indexKey(bi.Hash), bi.Serialize()); err != nil {
+			return err
+		}
+		if chain.txIndex {
+			if err := txn.Set(txIndexKey, []byte{1}); err != nil {
+				return err
+			}
+		}
+		if chain.addrIndex {
+			if err := txn.Set(addrIndexKey, []byte{1}); err != nil {
+				return err
+			}
 		}
-		err = txn.Set([]byte("lh"), genesis.Hash)
 		lastHash = genesis.Hash
-		return err
+		return chain.connectTip(txn, genesis)
 	})
 	if err != nil {
-		log.Panic(err)
+		chain.Close()
+		return nil, err
+	}
+	chain.LastHash = lastHash
+	return chain, nil
+}
+
+func ContinueBlockchain(opts Options) (*Blockchain, error) {
+	params, err := opts.NetworkParams()
+	if err != nil {
+		return nil, err
+	}
+	if DBExists(opts) == false {
+		return nil, ErrNoChain
+	}
+	db, err := ConnectDB(opts)
+	if err != nil {
+		return nil, err
+	}
+	lastHash, err := getLastHash(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	engine, err := loadConsensus(db, opts.Consensus)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	txIndex, err := hasTxIndex(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	addrIndex, err := hasAddrIndex(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	chain := Blockchain{lastHash, db, opts, params, engine, NewEventBus(), txIndex, addrIndex, ""}
+	if err := chain.buildIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := chain.buildHeightIndex(); err != nil {
+		db.Close()
+		return nil, err
 	}
-	return &Blockchain{lastHash, db}
+	return &chain, nil
+}
+
+func (chain *Blockchain) Params() *NetworkParams {
+	return chain.params
+}
+
+func (chain *Blockchain) Consensus() Consensus {
+	return chain.engine
 }
 
-func ContinueBlockchain(address string) *Blockchain {
-	if DBExists() == false {
-		fmt.Println("No existing blockchain found, create one!")
-		runtime.Goexit()
+// Events reports the blocks connected to and disconnected from the best
+// chain and the transactions mempools accept
+func (chain *Blockchain) Events() *EventBus {
+	return chain.events
+}
+
+func (chain *Blockchain) Close() error {
+	err := chain.Database.Close()
+	if chain.tempDir != "" {
+		if rmErr := os.RemoveAll(chain.tempDir); err == nil {
+			err = rmErr
+		}
 	}
+	return err
+}
+
+func getLastHash(db *badger.DB) ([]byte, error) {
 	var lastHash []byte
-	db := ConnectDB()
-	err := db.Update(func(txn *badger.Txn) error {
+	err := db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("lh"))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		err = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
+		lastHash, err = item.ValueCopy(nil)
 		return err
 	})
+	return lastHash, err
+}
+
+func (chain *Blockchain) AddBlock(transactions []*Transaction) (*Block, error) {
+	return chain.MineBlock(context.Background(), transactions, MineOptions{})
+}
+
+// PrepareBlock checks transactions against the current tip and builds the
+// unsealed block that would extend it
+func (chain *Blockchain) PrepareBlock(transactions []*Transaction) (*Block, error) {
+	lastHash, err := getLastHash(chain.Database)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := chain.GetBlockIndex(lastHash)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	candidate := &Block{BlockHeader: BlockHeader{PrevHash: lastHash, Height: parent.Height + 1}, Transactions: transactions}
+	if err := checkBlockTransactions(candidate); err != nil {
+		return nil, err
+	}
+	if err := chain.checkTransactions(candidate); err != nil {
+		return nil, err
 	}
-	chain := Blockchain{lastHash, db}
-	return &chain
+	block := NewBlock(transactions, lastHash, parent.Height+1, 0)
+	if err := chain.engine.Prepare(chain, &block.BlockHeader, parent); err != nil {
+		return nil, err
+	}
+	return block, nil
 }
 
-func (chain *Blockchain) AddBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	err := chain.Database.View(func(txn *badger.Txn) error {
+// SealBlock runs the consensus engine over a prepared block
+func (chain *Blockchain) SealBlock(ctx context.Context, block *Block, opts MineOptions) error {
+	return chain.engine.Seal(ctx, block, opts)
+}
+
+// MineBlock prepares, seals and connects a block on top of the tip, the block
+// and the UTXO set are stored together. It fails with ErrStaleBlock if the
+// tip moved while mining
+func (chain *Blockchain) MineBlock(ctx context.Context, transactions []*Transaction, opts MineOptions) (*Block, error) {
+	newBlock, err := chain.PrepareBlock(transactions)
+	if err != nil {
+		return nil, err
+	}
+	if err := chain.SealBlock(ctx, newBlock, opts); err != nil {
+		return nil, err
+	}
+	parent, err := chain.GetBlockIndex(newBlock.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+	bi := chain.newBlockIndex(newBlock, parent)
+	err = chain.Database.Update(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("lh"))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		err = item.Value(func(val []byte) error {
-			lastHash = val
-			return nil
-		})
-		return err
+		lastHash, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(lastHash, newBlock.PrevHash) {
+			return ErrStaleBlock
+		}
+		if err := txn.Set(newBlock.Hash, newBlock.Serialize()); err != nil {
+			return err
+		}
+		if err := txn.Set(indexKey(bi.Hash), bi.Serialize()); err != nil {
+			return err
+		}
+		return chain.connectTip(txn, newBlock)
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	chain.LastHash = newBlock.Hash
+	chain.events.publishChange(&ChainChange{Connected: []*Block{newBlock}})
+	return newBlock, nil
+}
+
+func (chain *Blockchain) AcceptBlock(block *Block) (*ChainChange, error) {
+	if err := chain.CheckBlock(block); err != nil {
+		return nil, err
+	}
+	exists, err := chain.HasBlock(block.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return &ChainChange{}, nil
+	}
+	parent, err := chain.GetBlockIndex(block.PrevHash)
+	if err == ErrBlockNotFound {
+		return nil, ErrOrphanBlock
+	}
+	if err != nil {
+		return nil, err
 	}
-	newBlock := CreateBlock(transactions, lastHash)
+	if err := chain.checkHeader(block, parent); err != nil {
+		return nil, err
+	}
+	tip, err := chain.GetBlockIndex(chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	bi := chain.newBlockIndex(block, parent)
 	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
+		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+			return err
 		}
-		err = txn.Set([]byte("lh"), newBlock.Hash)
-		chain.LastHash = newBlock.Hash
+		return txn.Set(indexKey(bi.Hash), bi.Serialize())
+	})
+	if err != nil {
+		return nil, err
+	}
+	if bi.CumulativeWork().Cmp(tip.CumulativeWork()) <= 0 {
+		return &ChainChange{}, nil
+	}
+	return chain.reorganize(tip, bi)
+}
+
+func (chain *Blockchain) HasBlock(hash []byte) (bool, error) {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(hash)
 		return err
 	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (chain *Blockchain) GetBlock(hash []byte) (*Block, error) {
+	var block *Block
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(hash)
+		if err == badger.ErrKeyNotFound {
+			return ErrBlockNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			block, err = Deserialize(val)
+			return err
+		})
+	})
+	return block, err
+}
+
+// Height is the number of blocks on top of the genesis block
+func (chain *Blockchain) Height() (int, error) {
+	tip, err := chain.GetBlockIndex(chain.LastHash)
+	if err != nil {
+		return 0, err
+	}
+	return tip.Height, nil
+}
+
+// NextSubsidy is the subsidy of the block extending the tip
+func (chain *Blockchain) NextSubsidy() (int, error) {
+	height, err := chain.Height()
 	if err != nil {
-		log.Panic(err)
+		return 0, err
 	}
-	return newBlock
+	return chain.params.Subsidy(height + 1), nil
 }
 
 func (chain *Blockchain) Iterator() *BlockchainIterator {
@@ -131,31 +378,34 @@ func (chain *Blockchain) Iterator() *BlockchainIterator {
 	return iter
 }
 
-func (iter *BlockchainIterator) Next() *Block {
+func (iter *BlockchainIterator) Next() (*Block, error) {
 	var block *Block
 	err := iter.Database.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(iter.CurrentHash)
-		var encodeBlock []byte
-		_ = item.Value(func(val []byte) error {
-			encodeBlock = val
-			return nil
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			block, err = Deserialize(val)
+			return err
 		})
-		block = Deserialize(encodeBlock)
-		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 	iter.CurrentHash = block.PrevHash
-	return block
+	return block, nil
 }
 
-func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
+func (chain *Blockchain) FindUTXO() (map[string]TxOutputs, error) {
 	UTXO := make(map[string]TxOutputs)
 	spentTXOs := make(map[string][]int)
 	iter := chain.Iterator()
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
 		Outputs:
@@ -168,7 +418,7 @@ func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
 					}
 				}
 				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				outs.Add(outIdx, out)
 				UTXO[txID] = outs
 			}
 			if tx.IsCoinbase() == false {
@@ -182,45 +432,77 @@ func (chain *Blockchain) FindUTXO() map[string]TxOutputs {
 			break
 		}
 	}
-	return UTXO
+	return UTXO, nil
 }
 
 func (chain *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	block, err := chain.FindTransactionBlock(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	for _, tx := range block.Transactions {
+		if bytes.Compare(tx.ID, ID) == 0 {
+			return *tx, nil
+		}
+	}
+	return Transaction{}, ErrTxNotFound
+}
+
+// FindTransactionBlock returns the best chain block holding a transaction,
+// chains without a transaction index are scanned from the tip
+func (chain *Blockchain) FindTransactionBlock(ID []byte) (*Block, error) {
+	if chain.txIndex {
+		loc, err := chain.locateTransaction(ID)
+		if err != nil {
+			return nil, err
+		}
+		return chain.GetBlock(loc.BlockHash)
+	}
 	iter := chain.Iterator()
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
+				return block, nil
 			}
 		}
 		if len(block.PrevHash) == 0 {
 			break
 		}
 	}
-	return Transaction{}, errors.New("transaction does not exists")
+	return nil, ErrTxNotFound
 }
 
-func (chain *Blockchain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
-	for _, in := range tx.Inputs {
-		prevTX, err := chain.FindTransaction(in.ID)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+func (chain *Blockchain) SignTransaction(tx *Transaction, privateKey ecdsa.PrivateKey) error {
+	prevTXs, err := chain.previousTransactions(tx)
+	if err != nil {
+		return err
 	}
-	tx.Sign(privateKey, prevTXs)
+	return tx.Sign(privateKey, prevTXs)
 }
 
-func (chain *Blockchain) VerifyTransaction(tx *Transaction) bool {
+func (chain *Blockchain) VerifyTransaction(tx *Transaction) (bool, error) {
+	if tx.IsCoinbase() {
+		return true, nil
+	}
+	prevTXs, err := chain.previousTransactions(tx)
+	if err != nil {
+		return false, err
+	}
+	return tx.Verify(prevTXs)
+}
+
+func (chain *Blockchain) previousTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
 	for _, in := range tx.Inputs {
 		prevTX, err := chain.FindTransaction(in.ID)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
-	return tx.Verify(prevTXs)
+	return prevTXs, nil
 }
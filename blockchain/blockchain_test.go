@@ -0,0 +1,63 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+func TestInitFreshDataDir(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, err := NewTestChain(Options{DataDir: filepath.Join(t.TempDir(), "fresh")}, string(w.NetworkAddress(RegTestParams.AddressVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := chain.Options
+	if err := chain.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !DBExists(opts) {
+		t.Fatalf("no chain in %s", opts.BlocksDir())
+	}
+	chain, err = ContinueBlockchain(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+	if height, err := chain.Height(); err != nil || height != 0 {
+		t.Fatalf("height %d, error %v", height, err)
+	}
+}
+
+func TestMineBlockConnects(t *testing.T) {
+	chain, w := newTestChain(t, Options{})
+	tx := spendGenesis(t, chain, w, 40, 60)
+	coinbase, err := CoinbaseTx(string(w.NetworkAddress(RegTestParams.AddressVersion)), "", 100, chain.Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := chain.MineBlock(context.Background(), []*Transaction{coinbase, tx}, MineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := UTXOSet{chain}
+	if _, err := UTXOSet.FindOutput(tx.Inputs[0].ID, 0); err != ErrOutputSpent {
+		t.Fatalf("spent output lookup returned %v", err)
+	}
+	if out, err := UTXOSet.FindOutput(tx.ID, 1); err != nil || out.Value != 60 {
+		t.Fatalf("output %+v, error %v", out, err)
+	}
+	if hash, err := chain.GetBlockHash(1); err != nil || !bytes.Equal(hash, block.Hash) {
+		t.Fatalf("height 1 is %x, error %v", hash, err)
+	}
+	// the undo data was written with the block
+	if err := UTXOSet.Disconnect(block); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UTXOSet.FindOutput(tx.Inputs[0].ID, 0); err != nil {
+		t.Fatalf("output not restored: %v", err)
+	}
+}
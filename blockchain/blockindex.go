@@ -0,0 +1,332 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/dgraph-io/badger"
+	"log"
+	"math/big"
+)
+
+var indexPrefix = []byte("bi-")
+
+// BlockIndex links every stored block, on the best chain or not, to its
+// parent and records the work needed to build the chain up to it
+type BlockIndex struct {
+	Hash      []byte
+	PrevHash  []byte
+	Height    int
+	Bits      int
+	Timestamp int64
+	Work      []byte
+}
+
+// ChainChange describes how the best chain moved, Disconnected lists the
+// blocks removed from the old tip downwards and Connected the blocks added
+// from the fork point upwards
+type ChainChange struct {
+	Disconnected []*Block
+	Connected    []*Block
+}
+
+func (chain *Blockchain) newBlockIndex(block *Block, parent *BlockIndex) *BlockIndex {
+	work := chain.engine.Work(block)
+	height := 0
+	if parent != nil {
+		work.Add(work, parent.CumulativeWork())
+		height = parent.Height + 1
+	}
+	return &BlockIndex{block.Hash, block.PrevHash, height, block.Bits, block.Timestamp, work.Bytes()}
+}
+
+func (bi *BlockIndex) CumulativeWork() *big.Int {
+	return new(big.Int).SetBytes(bi.Work)
+}
+
+func (bi BlockIndex) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(bi)
+	if err != nil {
+		log.Panic(err)
+	}
+	return res.Bytes()
+}
+
+func DeserializeBlockIndex(data []byte) (*BlockIndex, error) {
+	var bi BlockIndex
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&bi); err != nil {
+		return nil, err
+	}
+	return &bi, nil
+}
+
+func indexKey(hash []byte) []byte {
+	return append(append([]byte{}, indexPrefix...), hash...)
+}
+
+func getBlockIndex(txn *badger.Txn, hash []byte) (*BlockIndex, error) {
+	item, err := txn.Get(indexKey(hash))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrBlockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlockIndex(value)
+}
+
+func (chain *Blockchain) GetBlockIndex(hash []byte) (*BlockIndex, error) {
+	var bi *BlockIndex
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		bi, err = getBlockIndex(txn, hash)
+		return err
+	})
+	return bi, err
+}
+
+// buildIndex indexes the best chain of databases created before blocks were
+// indexed
+func (chain *Blockchain) buildIndex() error {
+	_, err := chain.GetBlockIndex(chain.LastHash)
+	if err != ErrBlockNotFound {
+		return err
+	}
+	var blocks []*Block
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		var parent *BlockIndex
+		for i := len(blocks) - 1; i >= 0; i-- {
+			bi := chain.newBlockIndex(blocks[i], parent)
+			if err := txn.Set(indexKey(bi.Hash), bi.Serialize()); err != nil {
+				return err
+			}
+			parent = bi
+		}
+		return nil
+	})
+}
+
+// reorganize makes newTip the tip of the best chain and brings the UTXO set
+// along
+func (chain *Blockchain) reorganize(oldTip, newTip *BlockIndex) (*ChainChange, error) {
+	var connect, disconnect [][]byte
+	oldIdx, newIdx := oldTip, newTip
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		for newIdx.Height > oldIdx.Height {
+			connect = append(connect, newIdx.Hash)
+			if newIdx, err = getBlockIndex(txn, newIdx.PrevHash); err != nil {
+				return err
+			}
+		}
+		for oldIdx.Height > newIdx.Height {
+			disconnect = append(disconnect, oldIdx.Hash)
+			if oldIdx, err = getBlockIndex(txn, oldIdx.PrevHash); err != nil {
+				return err
+			}
+		}
+		for !bytes.Equal(oldIdx.Hash, newIdx.Hash) {
+			connect = append(connect, newIdx.Hash)
+			disconnect = append(disconnect, oldIdx.Hash)
+			if newIdx, err = getBlockIndex(txn, newIdx.PrevHash); err != nil {
+				return err
+			}
+			if oldIdx, err = getBlockIndex(txn, oldIdx.PrevHash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fork := oldIdx.Hash
+	change := &ChainChange{}
+	for _, hash := range disconnect {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		change.Disconnected = append(change.Disconnected, block)
+	}
+	for i := len(connect) - 1; i >= 0; i-- {
+		block, err := chain.GetBlock(connect[i])
+		if err != nil {
+			return nil, err
+		}
+		change.Connected = append(change.Connected, block)
+	}
+	// the tip moves one block at a time so every block is checked against
+	// the UTXO set and the indexes of its parent
+	for i, block := range change.Disconnected {
+		err := chain.disconnectBlock(block)
+		if err == ErrNoUndoData {
+			// blocks connected before undo data existed can only be
+			// reverted by rebuilding the set at the fork point
+			if err := chain.rewind(fork, change.Disconnected[i:]); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, block := range change.Connected {
+		err := chain.connectBlock(block)
+		if err == nil {
+			continue
+		}
+		if rbErr := chain.rollback(change.Connected[:i], change.Disconnected); rbErr != nil {
+			return nil, rbErr
+		}
+		if rmErr := chain.removeBlocks(change.Connected[i:]); rmErr != nil {
+			return nil, rmErr
+		}
+		return nil, err
+	}
+	chain.events.publishChange(change)
+	return change, nil
+}
+
+// connectTip moves the tip to a stored block extending it, the UTXO set, the
+// undo data, the heights and the indexes follow in the same transaction
+func (chain *Blockchain) connectTip(txn *badger.Txn, block *Block) error {
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.update(txn, block); err != nil {
+		return err
+	}
+	if err := txn.Set(heightKey(block.Height), block.Hash); err != nil {
+		return err
+	}
+	if chain.txIndex {
+		if err := indexBlockTransactions(txn, block); err != nil {
+			return err
+		}
+	}
+	return txn.Set([]byte("lh"), block.Hash)
+}
+
+// disconnectTip moves the tip back to the parent of block, undoing
+// connectTip in the same transaction
+func (chain *Blockchain) disconnectTip(txn *badger.Txn, block *Block) error {
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.disconnect(txn, block); err != nil {
+		return err
+	}
+	if err := txn.Delete(heightKey(block.Height)); err != nil {
+		return err
+	}
+	if chain.txIndex {
+		if err := unindexBlockTransactions(txn, block); err != nil {
+			return err
+		}
+	}
+	return txn.Set([]byte("lh"), block.PrevHash)
+}
+
+func (chain *Blockchain) connectBlock(block *Block) error {
+	if err := chain.ValidateBlock(block); err != nil {
+		return err
+	}
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return chain.connectTip(txn, block)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = block.Hash
+	return nil
+}
+
+func (chain *Blockchain) disconnectBlock(block *Block) error {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return chain.disconnectTip(txn, block)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = block.PrevHash
+	return nil
+}
+
+// rewind moves the tip down to fork without undo data, dropping blocks from
+// the heights and the indexes before the UTXO set is rebuilt at the fork
+func (chain *Blockchain) rewind(fork []byte, blocks []*Block) error {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			if err := txn.Delete(heightKey(block.Height)); err != nil {
+				return err
+			}
+			if chain.txIndex {
+				if err := unindexBlockTransactions(txn, block); err != nil {
+					return err
+				}
+			}
+			if chain.addrIndex {
+				if err := unindexAddresses(txn, block); err != nil {
+					return err
+				}
+			}
+		}
+		return txn.Set([]byte("lh"), fork)
+	})
+	if err != nil {
+		return err
+	}
+	chain.LastHash = fork
+	UTXOSet := UTXOSet{chain}
+	return UTXOSet.Reindex()
+}
+
+// rollback undoes the connected part of a failed reorganization and brings
+// back the blocks it disconnected
+func (chain *Blockchain) rollback(connected, disconnected []*Block) error {
+	for i := len(connected) - 1; i >= 0; i-- {
+		if err := chain.disconnectBlock(connected[i]); err != nil {
+			return err
+		}
+	}
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		err := chain.Database.Update(func(txn *badger.Txn) error {
+			return chain.connectTip(txn, disconnected[i])
+		})
+		if err != nil {
+			return err
+		}
+		chain.LastHash = disconnected[i].Hash
+	}
+	return nil
+}
+
+// removeBlocks forgets blocks that failed validation
+func (chain *Blockchain) removeBlocks(blocks []*Block) error {
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			if err := txn.Delete(block.Hash); err != nil {
+				return err
+			}
+			if err := txn.Delete(indexKey(block.Hash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,230 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+// mineOn seals a block on top of any stored block, its coinbase pays w the
+// subsidy
+func mineOn(t *testing.T, chain *Blockchain, parentHash []byte, w *wallet.Wallet, txs ...*Transaction) *Block {
+	t.Helper()
+	parent, err := chain.GetBlockIndex(parentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := string(w.NetworkAddress(RegTestParams.AddressVersion))
+	coinbase, err := CoinbaseTx(address, "", chain.Params().Subsidy(parent.Height+1), chain.Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock(append([]*Transaction{coinbase}, txs...), parentHash, parent.Height+1, 0)
+	if err := chain.engine.Prepare(chain, &block.BlockHeader, parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.engine.Seal(context.Background(), block, MineOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// spend signs a transaction moving output out of prevTX, which w owns, into
+// outputs of the given values paying to
+func spend(t *testing.T, prevTX *Transaction, out int, w, to *wallet.Wallet, values ...int) *Transaction {
+	t.Helper()
+	tx := Transaction{nil, []TxInput{{prevTX.ID, out, nil, w.PublicKey}}, nil}
+	for _, value := range values {
+		tx.Outputs = append(tx.Outputs, TxOutput{value, wallet.PublicKeyHash(to.PublicKey)})
+	}
+	tx.ID = tx.Hash()
+	if err := tx.Sign(w.PrivateKey, map[string]Transaction{hex.EncodeToString(prevTX.ID): *prevTX}); err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+func accept(t *testing.T, chain *Blockchain, block *Block) *ChainChange {
+	t.Helper()
+	change, err := chain.AcceptBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return change
+}
+
+// balance sums the unspent outputs of a wallet
+func balance(t *testing.T, chain *Blockchain, w *wallet.Wallet) int {
+	t.Helper()
+	UTXOSet := UTXOSet{chain}
+	outs, err := UTXOSet.FindUnspentTransactions(wallet.PublicKeyHash(w.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := 0
+	for _, out := range outs {
+		sum += out.Value
+	}
+	return sum
+}
+
+func checkBalances(t *testing.T, chain *Blockchain, want map[*wallet.Wallet]int) {
+	t.Helper()
+	for w, value := range want {
+		if got := balance(t, chain, w); got != value {
+			t.Errorf("balance of %s is %d, want %d", w.NetworkAddress(RegTestParams.AddressVersion), got, value)
+		}
+	}
+}
+
+// forkedChain mines a tip paying a that also moves the genesis coinbase from
+// w to a, then stores a fork block paying b of the same work
+func forkedChain(t *testing.T, opts Options) (chain *Blockchain, w, a, b *wallet.Wallet, main, fork *Block) {
+	t.Helper()
+	chain, w = newTestChain(t, opts)
+	a, b = wallet.MakeWallet(), wallet.MakeWallet()
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main = mineOn(t, chain, genesis.Hash, a, spend(t, genesis.Transactions[0], 0, w, a, 100))
+	accept(t, chain, main)
+	fork = mineOn(t, chain, genesis.Hash, b)
+	accept(t, chain, fork)
+	if !bytes.Equal(chain.LastHash, main.Hash) {
+		t.Fatalf("fork of equal work took the tip")
+	}
+	return chain, w, a, b, main, fork
+}
+
+func TestReorgReplacesTip(t *testing.T) {
+	chain, w, a, b, main, fork := forkedChain(t, Options{})
+	sub := chain.Events().Subscribe(16)
+	defer sub.Unsubscribe()
+	fork2 := mineOn(t, chain, fork.Hash, b)
+	change := accept(t, chain, fork2)
+	if len(change.Disconnected) != 1 || !bytes.Equal(change.Disconnected[0].Hash, main.Hash) {
+		t.Fatalf("disconnected %v, want block %x", change.Disconnected, main.Hash)
+	}
+	if len(change.Connected) != 2 || !bytes.Equal(change.Connected[0].Hash, fork.Hash) || !bytes.Equal(change.Connected[1].Hash, fork2.Hash) {
+		t.Fatalf("connected %v, want blocks %x and %x", change.Connected, fork.Hash, fork2.Hash)
+	}
+	var blocks []EventType
+	for len(sub.C) > 0 {
+		if event := <-sub.C; event.Type != EventTxConfirmed {
+			blocks = append(blocks, event.Type)
+		}
+	}
+	if fmt.Sprint(blocks) != fmt.Sprint([]EventType{EventBlockDisconnected, EventBlockConnected, EventBlockConnected}) {
+		t.Fatalf("block events %v", blocks)
+	}
+	for height, block := range []*Block{fork, fork2} {
+		hash, err := chain.GetBlockHash(height + 1)
+		if err != nil || !bytes.Equal(hash, block.Hash) {
+			t.Fatalf("height %d is %x, error %v", height+1, hash, err)
+		}
+	}
+	if last, err := getLastHash(chain.Database); err != nil || !bytes.Equal(last, fork2.Hash) {
+		t.Fatalf("stored tip %x, error %v", last, err)
+	}
+	checkBalances(t, chain, map[*wallet.Wallet]int{w: 100, a: 0, b: 200})
+	UTXOSet := UTXOSet{chain}
+	if _, err := UTXOSet.FindOutput(main.Transactions[1].ID, 0); err != ErrOutputSpent {
+		t.Fatalf("output of a disconnected transaction lookup returned %v", err)
+	}
+}
+
+func TestReorgRollsBackInvalidFork(t *testing.T) {
+	chain, w, a, b, main, fork := forkedChain(t, Options{})
+	// the coinbase of main does not exist on the fork
+	bad := mineOn(t, chain, fork.Hash, b, spend(t, main.Transactions[0], 0, a, b, 100))
+	if _, err := chain.AcceptBlock(bad); !errors.Is(err, ErrOutputSpent) {
+		t.Fatalf("AcceptBlock returned %v, want %v", err, ErrOutputSpent)
+	}
+	if !bytes.Equal(chain.LastHash, main.Hash) {
+		t.Fatalf("tip is %x, want %x", chain.LastHash, main.Hash)
+	}
+	if last, err := getLastHash(chain.Database); err != nil || !bytes.Equal(last, main.Hash) {
+		t.Fatalf("stored tip %x, error %v", last, err)
+	}
+	if hash, err := chain.GetBlockHash(1); err != nil || !bytes.Equal(hash, main.Hash) {
+		t.Fatalf("height 1 is %x, error %v", hash, err)
+	}
+	if exists, err := chain.HasBlock(bad.Hash); err != nil || exists {
+		t.Fatalf("invalid block kept: %v", err)
+	}
+	checkBalances(t, chain, map[*wallet.Wallet]int{w: 0, a: 200, b: 0})
+
+	// the chain still follows a valid fork afterwards
+	accept(t, chain, mineOn(t, chain, fork.Hash, b))
+	checkBalances(t, chain, map[*wallet.Wallet]int{w: 100, a: 0, b: 200})
+}
+
+// TestReorgWithoutUndoData disconnects a block stored before undo data
+// existed, the UTXO set is rebuilt at the fork point instead
+func TestReorgWithoutUndoData(t *testing.T) {
+	chain, w, a, b, main, fork := forkedChain(t, Options{TxIndex: true, AddrIndex: true})
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(undoKey(main.Hash))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.Disconnect(main); err != ErrNoUndoData {
+		t.Fatalf("Disconnect returned %v, want %v", err, ErrNoUndoData)
+	}
+	fork2 := mineOn(t, chain, fork.Hash, b)
+	accept(t, chain, fork2)
+	if !bytes.Equal(chain.LastHash, fork2.Hash) {
+		t.Fatalf("tip is %x, want %x", chain.LastHash, fork2.Hash)
+	}
+	checkBalances(t, chain, map[*wallet.Wallet]int{w: 100, a: 0, b: 200})
+	if _, err := chain.FindTransaction(main.Transactions[1].ID); !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("disconnected transaction lookup returned %v", err)
+	}
+	history, total, err := chain.AddressHistory(wallet.PublicKeyHash(a.PublicKey), 0, 10)
+	if err != nil || total != 0 {
+		t.Fatalf("history of a %v, error %v", history, err)
+	}
+}
+
+// TestReorgSpendsForkOutputs switches to a fork whose second block spends the
+// coinbase of its first, the transaction index must follow every block
+func TestReorgSpendsForkOutputs(t *testing.T) {
+	for _, txIndex := range []bool{false, true} {
+		t.Run(fmt.Sprintf("txindex=%v", txIndex), func(t *testing.T) {
+			chain, w := newTestChain(t, Options{TxIndex: txIndex})
+			genesis := chain.LastHash
+			main := mineOn(t, chain, genesis, w)
+			accept(t, chain, main)
+
+			fork1 := mineOn(t, chain, genesis, w)
+			if change := accept(t, chain, fork1); len(change.Connected) != 0 {
+				t.Fatalf("fork of equal work connected %d blocks", len(change.Connected))
+			}
+			tx := spend(t, fork1.Transactions[0], 0, w, w, 100)
+			fork2 := mineOn(t, chain, fork1.Hash, w, tx)
+			change := accept(t, chain, fork2)
+			if len(change.Disconnected) != 1 || len(change.Connected) != 2 {
+				t.Fatalf("reorg disconnected %d and connected %d blocks", len(change.Disconnected), len(change.Connected))
+			}
+			if !bytes.Equal(chain.LastHash, fork2.Hash) {
+				t.Fatalf("tip is %x, want %x", chain.LastHash, fork2.Hash)
+			}
+			block, err := chain.FindTransactionBlock(tx.ID)
+			if err != nil || !bytes.Equal(block.Hash, fork2.Hash) {
+				t.Fatalf("transaction found in %v, error %v", block, err)
+			}
+			if _, err := chain.FindTransaction(main.Transactions[0].ID); !errors.Is(err, ErrTxNotFound) {
+				t.Fatalf("disconnected coinbase lookup returned %v", err)
+			}
+		})
+	}
+}
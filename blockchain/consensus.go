@@ -0,0 +1,246 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"github.com/nd-sin/blockchain/wallet"
+	"log"
+	"math/big"
+)
+
+var consensusKey = []byte("consensus")
+
+const (
+	EngineProofOfWork      = "pow"
+	EngineProofOfAuthority = "poa"
+)
+
+// Consensus decides who may extend the chain and how blocks prove it
+type Consensus interface {
+	// Name is stored with the chain so it is reopened with the same engine
+	Name() string
+	// Prepare fills the consensus fields of a header about to extend parent,
+	// parent is nil for the genesis block
+	Prepare(chain *Blockchain, header *BlockHeader, parent *BlockIndex) error
+	// Seal sets the block hash and whatever proof the engine requires
+	Seal(ctx context.Context, block *Block, opts MineOptions) error
+	// VerifySeal checks the proof of a block without any chain context
+	VerifySeal(block *Block) error
+	// Work is what the block adds to its chain when picking the best one
+	Work(block *Block) *big.Int
+}
+
+// ConsensusConfig is what a chain remembers of its engine, the signing key
+// of a validator is never stored
+type ConsensusConfig struct {
+	Engine     string
+	Validators [][]byte
+}
+
+func (cfg ConsensusConfig) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(cfg)
+	if err != nil {
+		log.Panic(err)
+	}
+	return res.Bytes()
+}
+
+func DeserializeConsensusConfig(data []byte) (*ConsensusConfig, error) {
+	var cfg ConsensusConfig
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func consensusConfig(engine Consensus) ConsensusConfig {
+	cfg := ConsensusConfig{Engine: engine.Name()}
+	if poa, ok := engine.(*ProofOfAuthority); ok {
+		cfg.Validators = poa.Validators
+	}
+	return cfg
+}
+
+// NewConsensus builds a verifying engine from a stored configuration
+func NewConsensus(cfg ConsensusConfig) (Consensus, error) {
+	switch cfg.Engine {
+	case EngineProofOfWork:
+		return ProofOfWorkEngine{}, nil
+	case EngineProofOfAuthority:
+		return NewProofOfAuthority(cfg.Validators, nil), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownConsensus, cfg.Engine)
+}
+
+// loadConsensus picks the engine of a reopened chain, chains created before
+// engines were selectable use proof of work. An engine given by the caller
+// must have the stored configuration, validators included
+func loadConsensus(db *badger.DB, engine Consensus) (Consensus, error) {
+	cfg := &ConsensusConfig{Engine: EngineProofOfWork}
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(consensusKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		cfg, err = DeserializeConsensusConfig(value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if engine == nil {
+		return NewConsensus(*cfg)
+	}
+	if engine.Name() != cfg.Engine {
+		return nil, fmt.Errorf("%w: chain uses %q, not %q", ErrConsensusMismatch, cfg.Engine, engine.Name())
+	}
+	if !sameValidators(consensusConfig(engine).Validators, cfg.Validators) {
+		return nil, fmt.Errorf("%w: validators differ from the %d of the chain", ErrConsensusMismatch, len(cfg.Validators))
+	}
+	return engine, nil
+}
+
+// sameValidators compares validator lists regardless of their order
+func sameValidators(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[string]int)
+	for _, validator := range a {
+		count[string(validator)]++
+	}
+	for _, validator := range b {
+		if count[string(validator)] == 0 {
+			return false
+		}
+		count[string(validator)]--
+	}
+	return true
+}
+
+// ProofOfWorkEngine is the SHA-256 proof of work, its difficulty follows the
+// network parameters
+type ProofOfWorkEngine struct{}
+
+func (ProofOfWorkEngine) Name() string {
+	return EngineProofOfWork
+}
+
+func (ProofOfWorkEngine) Prepare(chain *Blockchain, header *BlockHeader, parent *BlockIndex) error {
+	if parent == nil {
+		header.Bits = chain.Params().InitialBits
+		return nil
+	}
+	bits, err := chain.NextBits(parent)
+	if err != nil {
+		return err
+	}
+	header.Bits = bits
+	return nil
+}
+
+func (ProofOfWorkEngine) Seal(ctx context.Context, block *Block, opts MineOptions) error {
+	return block.Seal(ctx, opts)
+}
+
+func (ProofOfWorkEngine) VerifySeal(block *Block) error {
+	if block.Bits < 1 || block.Bits > 255 {
+		return fmt.Errorf("%w: bits %d out of range", ErrBadHeader, block.Bits)
+	}
+	if !NewProof(block).Validate() {
+		return fmt.Errorf("%w: block %x", ErrInvalidPoW, block.Hash)
+	}
+	return nil
+}
+
+func (ProofOfWorkEngine) Work(block *Block) *big.Int {
+	return NewProof(block).Work()
+}
+
+// ProofOfAuthority lets a fixed set of validators produce blocks instantly by
+// signing them, every block adds the same work so the longest chain wins
+type ProofOfAuthority struct {
+	// Validators are the encoded public keys allowed to sign blocks
+	Validators [][]byte
+	// Signer is needed to seal blocks, it must be one of the validators
+	Signer *ecdsa.PrivateKey
+}
+
+func NewProofOfAuthority(validators [][]byte, signer *ecdsa.PrivateKey) *ProofOfAuthority {
+	return &ProofOfAuthority{validators, signer}
+}
+
+func (poa *ProofOfAuthority) Name() string {
+	return EngineProofOfAuthority
+}
+
+func (poa *ProofOfAuthority) IsValidator(pubKey []byte) bool {
+	for _, validator := range poa.Validators {
+		if bytes.Equal(validator, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (poa *ProofOfAuthority) Prepare(chain *Blockchain, header *BlockHeader, parent *BlockIndex) error {
+	header.Bits = 0
+	header.Nonce = 0
+	return nil
+}
+
+func (poa *ProofOfAuthority) Seal(ctx context.Context, block *Block, opts MineOptions) error {
+	if poa.Signer == nil {
+		return ErrNotValidator
+	}
+	pubKey := wallet.EncodePublicKey(&poa.Signer.PublicKey)
+	if !poa.IsValidator(pubKey) {
+		return ErrNotValidator
+	}
+	hash := sha256.Sum256(NewProof(block).InitData(block.Nonce))
+	signature, err := wallet.Sign(*poa.Signer, hash[:])
+	if err != nil {
+		return err
+	}
+	block.Hash = hash[:]
+	block.Signer = pubKey
+	block.Signature = signature
+	return nil
+}
+
+func (poa *ProofOfAuthority) VerifySeal(block *Block) error {
+	if block.Bits != 0 {
+		return fmt.Errorf("%w: bits %d on an authority block", ErrBadHeader, block.Bits)
+	}
+	hash := sha256.Sum256(NewProof(block).InitData(block.Nonce))
+	if !bytes.Equal(hash[:], block.Hash) {
+		return fmt.Errorf("%w: block %x", ErrInvalidSeal, block.Hash)
+	}
+	if !poa.IsValidator(block.Signer) {
+		return fmt.Errorf("%w: block %x signed by %x", ErrNotValidator, block.Hash, block.Signer)
+	}
+	valid, err := wallet.VerifySignature(block.Signer, block.Hash, block.Signature)
+	if err != nil || !valid {
+		return fmt.Errorf("%w: block %x", ErrInvalidSeal, block.Hash)
+	}
+	return nil
+}
+
+func (poa *ProofOfAuthority) Work(block *Block) *big.Int {
+	return big.NewInt(1)
+}
@@ -0,0 +1,49 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+func TestReopenWithOtherValidators(t *testing.T) {
+	v1, v2 := wallet.MakeWallet(), wallet.MakeWallet()
+	engine := NewProofOfAuthority([][]byte{v1.PublicKey, v2.PublicKey}, &v1.PrivateKey)
+	chain, err := NewTestChain(Options{DataDir: t.TempDir(), Consensus: engine}, string(v1.NetworkAddress(RegTestParams.AddressVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := chain.Options
+	if err := chain.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		engine Consensus
+		err    error
+	}{
+		{"stored", nil, nil},
+		{"same validators", NewProofOfAuthority([][]byte{v2.PublicKey, v1.PublicKey}, &v2.PrivateKey), nil},
+		{"fewer validators", NewProofOfAuthority([][]byte{v1.PublicKey}, &v1.PrivateKey), ErrConsensusMismatch},
+		{"other validators", NewProofOfAuthority([][]byte{v1.PublicKey, wallet.MakeWallet().PublicKey}, nil), ErrConsensusMismatch},
+		{"duplicated validator", NewProofOfAuthority([][]byte{v1.PublicKey, v1.PublicKey}, nil), ErrConsensusMismatch},
+		{"proof of work", ProofOfWorkEngine{}, ErrConsensusMismatch},
+	} {
+		opts.Consensus = tt.engine
+		chain, err := ContinueBlockchain(opts)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%s: ContinueBlockchain returned %v, want %v", tt.name, err, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		poa, ok := chain.Consensus().(*ProofOfAuthority)
+		if !ok || !poa.IsValidator(v1.PublicKey) || !poa.IsValidator(v2.PublicKey) {
+			t.Errorf("%s: engine %+v", tt.name, chain.Consensus())
+		}
+		if err := chain.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
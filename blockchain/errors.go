@@ -0,0 +1,38 @@
+package blockchain
+
+import (
+	"errors"
+)
+
+var (
+	ErrChainExists       = errors.New("blockchain already exists")
+	ErrNoChain           = errors.New("no existing blockchain found")
+	ErrInsufficientFunds = errors.New("not enough funds")
+	ErrTxNotFound        = errors.New("transaction does not exist")
+	ErrInvalidPrevTx     = errors.New("previous transaction is not correct")
+	ErrBlockNotFound     = errors.New("block does not exist")
+	ErrInvalidPoW        = errors.New("block proof of work is not valid")
+	ErrOrphanBlock       = errors.New("parent block is unknown")
+	ErrNoUndoData        = errors.New("block has no undo data")
+	ErrBadPrevHash       = errors.New("block does not extend the chain tip")
+	ErrBadHeader         = errors.New("block header is not valid")
+	ErrBadMerkleRoot     = errors.New("block merkle root does not match its transactions")
+	ErrNonceExhausted    = errors.New("no nonce meets the target")
+	ErrStaleBlock        = errors.New("chain tip moved while the block was mined")
+	ErrInvalidSeal       = errors.New("block seal is not valid")
+	ErrNotValidator      = errors.New("signer is not a validator")
+	ErrUnknownConsensus  = errors.New("unknown consensus engine")
+	ErrConsensusMismatch = errors.New("chain was created with another consensus engine")
+	ErrBadCoinbase       = errors.New("block coinbase is not valid")
+	ErrBadTxID           = errors.New("transaction ID does not match its contents")
+	ErrBadTransaction    = errors.New("transaction is malformed")
+	ErrInvalidSignature  = errors.New("transaction signature is not valid")
+	ErrOutputSpent       = errors.New("output is spent or does not exist")
+	ErrDoubleSpend       = errors.New("output is already spent by another transaction")
+	ErrTxInMempool       = errors.New("transaction is already in the mempool")
+	ErrCoinbaseTx        = errors.New("coinbase transactions are only valid in blocks")
+	ErrInvalidValue      = errors.New("transaction outputs exceed its inputs")
+	ErrValueOutOfRange   = errors.New("value is out of range")
+	ErrNoAddressIndex    = errors.New("address index is not built, run reindex -addrindex")
+	ErrUnknownNetwork    = errors.New("unknown network")
+)
@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"sync"
+)
+
+type EventType string
+
+const (
+	EventBlockConnected    EventType = "blockconnected"
+	EventBlockDisconnected EventType = "blockdisconnected"
+	EventTxAccepted        EventType = "txaccepted"
+	EventTxConfirmed       EventType = "txconfirmed"
+)
+
+// Event reports a change of the best chain or of the mempool. Block events
+// and TxConfirmed carry the block, transaction events the transaction
+type Event struct {
+	Type  EventType
+	Block *Block
+	Tx    *Transaction
+}
+
+// EventBus hands events to its subscribers without ever blocking the
+// publisher, it is safe for concurrent use
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+// Subscription receives events on C until it is unsubscribed. A subscriber
+// letting its buffer fill up is dropped and C is closed, so it knows events
+// were lost
+type Subscription struct {
+	C <-chan Event
+
+	ch  chan Event
+	bus *EventBus
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]bool)}
+}
+
+func (bus *EventBus) Subscribe(buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{ch, ch, bus}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.subs[sub] = true
+	return sub
+}
+
+func (sub *Subscription) Unsubscribe() {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+	sub.bus.remove(sub)
+}
+
+func (bus *EventBus) remove(sub *Subscription) {
+	if bus.subs[sub] {
+		delete(bus.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (bus *EventBus) Publish(event Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for sub := range bus.subs {
+		select {
+		case sub.ch <- event:
+		default:
+			bus.remove(sub)
+		}
+	}
+}
+
+// publishChange reports the blocks of a chain change, the disconnected ones
+// first
+func (bus *EventBus) publishChange(change *ChainChange) {
+	for _, block := range change.Disconnected {
+		bus.Publish(Event{EventBlockDisconnected, block, nil})
+	}
+	for _, block := range change.Connected {
+		bus.Publish(Event{EventBlockConnected, block, nil})
+		for _, tx := range block.Transactions {
+			bus.Publish(Event{EventTxConfirmed, block, tx})
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/dgraph-io/badger"
+)
+
+// heightPrefix keys the hash of the best chain block at every height, the
+// height is big endian so keys sort by height
+var heightPrefix = []byte("bh-")
+
+func heightKey(height int) []byte {
+	key := make([]byte, len(heightPrefix)+8)
+	copy(key, heightPrefix)
+	binary.BigEndian.PutUint64(key[len(heightPrefix):], uint64(height))
+	return key
+}
+
+func getHeightHash(txn *badger.Txn, height int) ([]byte, error) {
+	if height < 0 {
+		return nil, ErrBlockNotFound
+	}
+	item, err := txn.Get(heightKey(height))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrBlockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// buildHeightIndex indexes the heights of databases created before heights
+// were indexed, or extended since by a version that did not index them
+func (chain *Blockchain) buildHeightIndex() error {
+	tip, err := chain.GetBlockIndex(chain.LastHash)
+	if err != nil {
+		return err
+	}
+	var hash []byte
+	err = chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		hash, err = getHeightHash(txn, tip.Height)
+		return err
+	})
+	if err == nil && bytes.Equal(hash, tip.Hash) {
+		return nil
+	}
+	if err != nil && err != ErrBlockNotFound {
+		return err
+	}
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.DeleteByPrefix(heightPrefix); err != nil {
+		return err
+	}
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		bi := tip
+		for {
+			if err := txn.Set(heightKey(bi.Height), bi.Hash); err != nil {
+				return err
+			}
+			if len(bi.PrevHash) == 0 {
+				return nil
+			}
+			var err error
+			if bi, err = getBlockIndex(txn, bi.PrevHash); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// GetBlockHash returns the hash of the best chain block at height
+func (chain *Blockchain) GetBlockHash(height int) ([]byte, error) {
+	var hash []byte
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		hash, err = getHeightHash(txn, height)
+		return err
+	})
+	return hash, err
+}
+
+func (chain *Blockchain) GetBlockByHeight(height int) (*Block, error) {
+	hash, err := chain.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+	return chain.GetBlock(hash)
+}
+
+// GetBlockHashes returns the hashes of the best chain blocks from height from
+// to height to, both included, in height order. to is capped at the tip
+func (chain *Blockchain) GetBlockHashes(from, to int) ([][]byte, error) {
+	height, err := chain.Height()
+	if err != nil {
+		return nil, err
+	}
+	if to > height {
+		to = height
+	}
+	if from < 0 {
+		from = 0
+	}
+	var hashes [][]byte
+	err = chain.Database.View(func(txn *badger.Txn) error {
+		for h := from; h <= to; h++ {
+			hash, err := getHeightHash(txn, h)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+		return nil
+	})
+	return hashes, err
+}
+
+// ForwardIterator walks the best chain from a height up to the tip
+type ForwardIterator struct {
+	Height int
+	chain  *Blockchain
+}
+
+func (chain *Blockchain) ForwardIterator(from int) *ForwardIterator {
+	return &ForwardIterator{from, chain}
+}
+
+// Next returns the block at the iterator height and moves up, it returns nil
+// once the iterator passed the tip
+func (iter *ForwardIterator) Next() (*Block, error) {
+	block, err := iter.chain.GetBlockByHeight(iter.Height)
+	if err == ErrBlockNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	iter.Height++
+	return block, nil
+}
@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// checkHeights compares the height index with the blocks of the best chain
+func checkHeights(t *testing.T, chain *Blockchain, want ...*Block) {
+	t.Helper()
+	hashes, err := chain.GetBlockHashes(-1, len(want)+5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != len(want) {
+		t.Fatalf("GetBlockHashes returned %d hashes, want %d", len(hashes), len(want))
+	}
+	iter := chain.ForwardIterator(0)
+	for height, block := range want {
+		if !bytes.Equal(hashes[height], block.Hash) {
+			t.Errorf("GetBlockHashes has %x at height %d, want %x", hashes[height], height, block.Hash)
+		}
+		if hash, err := chain.GetBlockHash(height); err != nil || !bytes.Equal(hash, block.Hash) {
+			t.Errorf("height %d is %x, error %v, want %x", height, hash, err, block.Hash)
+		}
+		next, err := iter.Next()
+		if err != nil || next == nil || !bytes.Equal(next.Hash, block.Hash) {
+			t.Fatalf("iterator returned %v, error %v at height %d", next, err, height)
+		}
+	}
+	if next, err := iter.Next(); err != nil || next != nil {
+		t.Fatalf("iterator went past the tip to %v, error %v", next, err)
+	}
+	if _, err := chain.GetBlockHash(len(want)); err != ErrBlockNotFound {
+		t.Fatalf("height above the tip returned %v, want %v", err, ErrBlockNotFound)
+	}
+}
+
+// TestHeightIndexFollowsReorgs switches to a fork and then back to a longer
+// main chain, disconnecting two blocks at once
+func TestHeightIndexFollowsReorgs(t *testing.T) {
+	chain, _, a, b, main, fork := forkedChain(t, Options{})
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHeights(t, chain, genesis, main)
+
+	fork2 := mineOn(t, chain, fork.Hash, b)
+	accept(t, chain, fork2)
+	checkHeights(t, chain, genesis, fork, fork2)
+
+	main2 := mineOn(t, chain, main.Hash, a)
+	accept(t, chain, main2)
+	main3 := mineOn(t, chain, main2.Hash, a)
+	change := accept(t, chain, main3)
+	if len(change.Disconnected) != 2 || len(change.Connected) != 3 {
+		t.Fatalf("disconnected %d and connected %d blocks, want 2 and 3", len(change.Disconnected), len(change.Connected))
+	}
+	checkHeights(t, chain, genesis, main, main2, main3)
+}
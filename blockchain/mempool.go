@@ -0,0 +1,315 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultMempoolMaxAge = 24 * time.Hour
+
+type mempoolEntry struct {
+	Tx    *Transaction
+	Added time.Time
+	Fee   int
+	Size  int
+}
+
+// betterFeeRate compares fee per serialized byte without dividing
+func (entry *mempoolEntry) betterFeeRate(other *mempoolEntry) bool {
+	return entry.Fee*other.Size > other.Fee*entry.Size
+}
+
+// Mempool holds verified transactions waiting to be mined, it is safe for
+// concurrent use
+type Mempool struct {
+	// MaxAge is how long a transaction may wait before EvictStale drops it
+	MaxAge time.Duration
+
+	utxo    UTXOSet
+	mu      sync.Mutex
+	entries map[string]*mempoolEntry
+	spent   map[string]string
+}
+
+func NewMempool(chain *Blockchain) *Mempool {
+	return &Mempool{
+		MaxAge:  DefaultMempoolMaxAge,
+		utxo:    UTXOSet{Blockchain: chain},
+		entries: make(map[string]*mempoolEntry),
+		spent:   make(map[string]string),
+	}
+}
+
+func outpoint(txID []byte, out int) string {
+	return fmt.Sprintf("%x:%d", txID, out)
+}
+
+// Add verifies a transaction against the chain and the pool and queues it
+func (mp *Mempool) Add(tx *Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[txID]; ok {
+		return ErrTxInMempool
+	}
+	if err := checkTransaction(tx); err != nil {
+		return err
+	}
+	fee, err := mp.check(tx)
+	if err != nil {
+		return err
+	}
+	valid, err := mp.utxo.Blockchain.VerifyTransaction(tx)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+	mp.entries[txID] = &mempoolEntry{tx, time.Now(), fee, len(tx.Serialize())}
+	for _, in := range tx.Inputs {
+		mp.spent[outpoint(in.ID, in.Out)] = txID
+	}
+	mp.utxo.Blockchain.Events().Publish(Event{EventTxAccepted, nil, tx})
+	return nil
+}
+
+// check makes sure every input is unspent both on chain and in the pool,
+// belongs to the key spending it and that the outputs do not create value, it returns the fee the transaction
+// leaves. The transaction must have passed checkTransaction
+func (mp *Mempool) check(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, ErrCoinbaseTx
+	}
+	seen := make(map[string]bool)
+	inputs := 0
+	for _, in := range tx.Inputs {
+		key := outpoint(in.ID, in.Out)
+		if seen[key] {
+			return 0, fmt.Errorf("%w: %s spent twice", ErrDoubleSpend, key)
+		}
+		seen[key] = true
+		if other, ok := mp.spent[key]; ok {
+			return 0, fmt.Errorf("%w: %s spent by %s", ErrDoubleSpend, key, other)
+		}
+		out, err := mp.utxo.FindOutput(in.ID, in.Out)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", err, key)
+		}
+		if !in.UsesKey(out.PubKeyHash) {
+			return 0, fmt.Errorf("%w: %s uses another key", ErrInvalidSignature, key)
+		}
+		if inputs, err = addValue(inputs, out.Value); err != nil {
+			return 0, fmt.Errorf("%w: inputs", err)
+		}
+	}
+	outputs := 0
+	for _, out := range tx.Outputs {
+		var err error
+		if outputs, err = addValue(outputs, out.Value); err != nil {
+			return 0, fmt.Errorf("%w: outputs", err)
+		}
+	}
+	if outputs > inputs {
+		return 0, ErrInvalidValue
+	}
+	return inputs - outputs, nil
+}
+
+func (mp *Mempool) Get(id []byte) (*Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	entry, ok := mp.entries[hex.EncodeToString(id)]
+	if !ok {
+		return nil, false
+	}
+	return entry.Tx, true
+}
+
+// Fee is what a pending transaction leaves to the miner
+func (mp *Mempool) Fee(id []byte) (int, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	entry, ok := mp.entries[hex.EncodeToString(id)]
+	if !ok {
+		return 0, false
+	}
+	return entry.Fee, true
+}
+
+func (mp *Mempool) Has(id []byte) bool {
+	_, ok := mp.Get(id)
+	return ok
+}
+
+// Unspent drops the outputs pending transactions already spend
+func (mp *Mempool) Unspent(outputs []UnspentOutput) []UnspentOutput {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	var unspent []UnspentOutput
+	for _, out := range outputs {
+		if _, ok := mp.spent[outpoint(out.TxID, out.Index)]; !ok {
+			unspent = append(unspent, out)
+		}
+	}
+	return unspent
+}
+
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.entries)
+}
+
+// Transactions returns the pending transactions, best fee rate first
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	var txs []*Transaction
+	for _, entry := range mp.sorted() {
+		txs = append(txs, entry.Tx)
+	}
+	return txs
+}
+
+// sorted orders the entries by fee rate, oldest first among equal rates
+func (mp *Mempool) sorted() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].betterFeeRate(entries[j]) {
+			return true
+		}
+		if entries[j].betterFeeRate(entries[i]) {
+			return false
+		}
+		return entries[i].Added.Before(entries[j].Added)
+	})
+	return entries
+}
+
+// Conflicts returns the pending transactions spending an output tx spends
+func (mp *Mempool) Conflicts(tx *Transaction) []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.conflicts(tx)
+}
+
+func (mp *Mempool) conflicts(tx *Transaction) []*Transaction {
+	var txs []*Transaction
+	txID := hex.EncodeToString(tx.ID)
+	seen := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		other, ok := mp.spent[outpoint(in.ID, in.Out)]
+		if !ok || other == txID || seen[other] {
+			continue
+		}
+		seen[other] = true
+		txs = append(txs, mp.entries[other].Tx)
+	}
+	return txs
+}
+
+func (mp *Mempool) Remove(id []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.remove(hex.EncodeToString(id))
+}
+
+func (mp *Mempool) remove(txID string) {
+	entry, ok := mp.entries[txID]
+	if !ok {
+		return
+	}
+	for _, in := range entry.Tx.Inputs {
+		delete(mp.spent, outpoint(in.ID, in.Out))
+	}
+	delete(mp.entries, txID)
+}
+
+// RemoveBlock drops the transactions mined in block along with every pending
+// transaction that conflicts with them
+func (mp *Mempool) RemoveBlock(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range block.Transactions {
+		for _, conflict := range mp.conflicts(tx) {
+			mp.remove(hex.EncodeToString(conflict.ID))
+		}
+		mp.remove(hex.EncodeToString(tx.ID))
+	}
+}
+
+// EvictStale drops transactions older than MaxAge and transactions whose
+// inputs were spent on chain, it returns how many were dropped
+func (mp *Mempool) EvictStale() (int, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	evicted := 0
+	now := time.Now()
+	for txID, entry := range mp.entries {
+		stale := mp.MaxAge > 0 && now.Sub(entry.Added) > mp.MaxAge
+		if !stale {
+			spendable, err := mp.spendable(entry.Tx)
+			if err != nil {
+				return evicted, err
+			}
+			stale = !spendable
+		}
+		if stale {
+			mp.remove(txID)
+			evicted++
+		}
+	}
+	return evicted, nil
+}
+
+func (mp *Mempool) spendable(tx *Transaction) (bool, error) {
+	for _, in := range tx.Inputs {
+		_, err := mp.utxo.FindOutput(in.ID, in.Out)
+		if err == ErrOutputSpent {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// BlockTemplate assembles the transactions of the next block, up to maxTxs
+// pending transactions with the best fee rates behind a coinbase paying
+// minerAddress the subsidy and their fees. maxTxs <= 0 means no limit
+func (mp *Mempool) BlockTemplate(minerAddress string, maxTxs int) ([]*Transaction, error) {
+	txs := []*Transaction{nil}
+	reward, err := mp.utxo.Blockchain.NextSubsidy()
+	if err != nil {
+		return nil, err
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, entry := range mp.sorted() {
+		if maxTxs > 0 && len(txs) > maxTxs {
+			break
+		}
+		spendable, err := mp.spendable(entry.Tx)
+		if err != nil {
+			return nil, err
+		}
+		if spendable {
+			txs = append(txs, entry.Tx)
+			reward += entry.Fee
+		}
+	}
+	coinbase, err := CoinbaseTx(minerAddress, "", reward, mp.utxo.Blockchain.Params())
+	if err != nil {
+		return nil, err
+	}
+	txs[0] = coinbase
+	return txs, nil
+}
@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+var ErrNotInTree = errors.New("leaf is not part of the merkle tree")
+
+// MerkleTree keeps every level of the tree, leaves first, so inclusion proofs
+// can be read off it. Levels with an odd number of nodes pair their last node
+// with itself
+type MerkleTree struct {
+	Levels [][][]byte
+}
+
+// MerkleProof proves Leaf is in a tree, Path holds the sibling hashes from the
+// leaf level up and Index the leaf position, whose bits tell on which side
+// each sibling sits
+type MerkleProof struct {
+	Leaf  []byte
+	Index int
+	Path  [][]byte
+}
+
+// Leaves and inner nodes hash under different prefixes, otherwise the
+// concatenation of two child hashes could pass for a leaf
+const (
+	merkleLeafPrefix  = 0x00
+	merkleInnerPrefix = 0x01
+)
+
+func merkleLeaf(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return hash[:]
+}
+
+func merkleParent(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{merkleInnerPrefix}, left...), right...))
+	return hash[:]
+}
+
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var level [][]byte
+	for _, d := range data {
+		level = append(level, merkleLeaf(d))
+	}
+	if len(level) == 0 {
+		level = append(level, merkleLeaf(nil))
+	}
+	tree := &MerkleTree{[][][]byte{level}}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, merkleParent(level[i], right))
+		}
+		tree.Levels = append(tree.Levels, next)
+		level = next
+	}
+	return tree
+}
+
+func (t *MerkleTree) Root() []byte {
+	return t.Levels[len(t.Levels)-1][0]
+}
+
+func (t *MerkleTree) Proof(data []byte) (*MerkleProof, error) {
+	leaf := merkleLeaf(data)
+	index := -1
+	for i, node := range t.Levels[0] {
+		if bytes.Equal(node, leaf) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, ErrNotInTree
+	}
+	proof := &MerkleProof{data, index, nil}
+	for _, level := range t.Levels[:len(t.Levels)-1] {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		proof.Path = append(proof.Path, level[sibling])
+		index /= 2
+	}
+	return proof, nil
+}
+
+func (p *MerkleProof) Verify(root []byte) bool {
+	hash := merkleLeaf(p.Leaf)
+	index := p.Index
+	for _, sibling := range p.Path {
+		if index%2 == 0 {
+			hash = merkleParent(hash, sibling)
+		} else {
+			hash = merkleParent(sibling, hash)
+		}
+		index /= 2
+	}
+	return index == 0 && bytes.Equal(hash, root)
+}
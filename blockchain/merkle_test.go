@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func merkleData(n int) [][]byte {
+	var data [][]byte
+	for i := 0; i < n; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprint(i)))
+		data = append(data, hash[:])
+	}
+	return data
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := merkleData(n)
+		tree := NewMerkleTree(data)
+		for i, d := range data {
+			proof, err := tree.Proof(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof.Index != i || !proof.Verify(tree.Root()) {
+				t.Fatalf("%d leaves: proof of leaf %d does not verify", n, i)
+			}
+			tampered := *proof
+			tampered.Leaf = append([]byte{}, d...)
+			tampered.Leaf[0] ^= 1
+			if tampered.Verify(tree.Root()) {
+				t.Fatalf("%d leaves: changed leaf %d verifies", n, i)
+			}
+			if sibling := i ^ 1; sibling < n {
+				moved := *proof
+				moved.Index = sibling
+				if moved.Verify(tree.Root()) {
+					t.Fatalf("%d leaves: leaf %d verifies at index %d", n, i, sibling)
+				}
+			}
+		}
+	}
+	if _, err := NewMerkleTree(merkleData(3)).Proof([]byte("missing")); err != ErrNotInTree {
+		t.Fatalf("Proof returned %v, want %v", err, ErrNotInTree)
+	}
+}
+
+// TestMerkleForgedLeaf passes the two children of an inner node off as a
+// leaf, at every level of the tree
+func TestMerkleForgedLeaf(t *testing.T) {
+	tree := NewMerkleTree(merkleData(8))
+	for level := 0; level < len(tree.Levels)-1; level++ {
+		nodes := tree.Levels[level]
+		leaf := append(append([]byte{}, nodes[0]...), nodes[1]...)
+		proof := MerkleProof{leaf, 0, nil}
+		for _, upper := range tree.Levels[level+1 : len(tree.Levels)-1] {
+			proof.Path = append(proof.Path, upper[1])
+		}
+		if proof.Verify(tree.Root()) {
+			t.Fatalf("children of level %d verify as a leaf", level)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger"
+	"path/filepath"
+)
+
+const DefaultDataDir = "./tmp"
+
+type Options struct {
+	// DataDir is the root directory holding every network's chain and wallets
+	DataDir string
+	// Network keeps chains of different networks apart under DataDir and
+	// selects the network parameters when Params is nil
+	Network string
+	// Params overrides the consensus rules of the chain
+	Params *NetworkParams
+	// Badger overrides the database options, its Dir and ValueDir are always
+	// set from DataDir and Network
+	Badger *badger.Options
+	// Consensus is the engine of a new chain, proof of work when nil. A
+	// reopened chain keeps its engine, one given here must match it
+	Consensus Consensus
+	// InMemory runs the chain from a throwaway directory that is removed on
+	// Close, badger v1 has no real in-memory mode
+	InMemory bool
+	// TxIndex indexes the transactions of a new chain by ID, a reopened
+	// chain keeps the index it has
+	TxIndex bool
+	// AddrIndex indexes the transactions of a new chain by address, a
+	// reopened chain keeps the index it has
+	AddrIndex bool
+}
+
+func DefaultOptions() Options {
+	return Options{DataDir: DefaultDataDir}
+}
+
+// Dir is the directory of the selected network, wallets are stored here too.
+// The main network lives in DataDir itself whether it is named or not
+func (opts Options) Dir() string {
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+	if opts.Network == "" || opts.Network == MainNetParams.Name {
+		return dataDir
+	}
+	return filepath.Join(dataDir, opts.Network)
+}
+
+// NetworkParams resolves the consensus rules, a network name without
+// parameters of its own is only accepted along with Params
+func (opts Options) NetworkParams() (*NetworkParams, error) {
+	if opts.Params != nil {
+		return opts.Params, nil
+	}
+	if params, ok := ParamsByName(opts.Network); ok {
+		return params, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownNetwork, opts.Network)
+}
+
+func (opts Options) BlocksDir() string {
+	return filepath.Join(opts.Dir(), "blocks")
+}
+
+func (opts Options) badgerOptions(dir string) badger.Options {
+	bopts := badger.DefaultOptions(dir)
+	if opts.Badger != nil {
+		bopts = *opts.Badger
+	}
+	return bopts.WithDir(dir).WithValueDir(dir)
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNetworkParams(t *testing.T) {
+	dataDir := t.TempDir()
+	for _, tt := range []struct {
+		network string
+		params  *NetworkParams
+		dir     string
+	}{
+		{"", &MainNetParams, dataDir},
+		{"main", &MainNetParams, dataDir},
+		{"test", &TestNetParams, filepath.Join(dataDir, "test")},
+		{"regtest", &RegTestParams, filepath.Join(dataDir, "regtest")},
+	} {
+		opts := Options{DataDir: dataDir, Network: tt.network}
+		params, err := opts.NetworkParams()
+		if err != nil || params != tt.params {
+			t.Errorf("network %q: params %v, error %v", tt.network, params, err)
+		}
+		if dir := opts.Dir(); dir != tt.dir {
+			t.Errorf("network %q: directory %s, want %s", tt.network, dir, tt.dir)
+		}
+	}
+
+	custom := Options{DataDir: dataDir, Network: "custom", Params: &RegTestParams}
+	if params, err := custom.NetworkParams(); err != nil || params != &RegTestParams {
+		t.Errorf("custom network: params %v, error %v", params, err)
+	}
+
+	typo := Options{DataDir: dataDir, Network: "tetsnet"}
+	if _, err := typo.NetworkParams(); !errors.Is(err, ErrUnknownNetwork) {
+		t.Fatalf("NetworkParams returned %v, want %v", err, ErrUnknownNetwork)
+	}
+	if _, err := InitBlockchain(typo, "address"); !errors.Is(err, ErrUnknownNetwork) {
+		t.Fatalf("InitBlockchain returned %v, want %v", err, ErrUnknownNetwork)
+	}
+	if _, err := os.Stat(typo.Dir()); !os.IsNotExist(err) {
+		t.Fatalf("unknown network directory created: %v", err)
+	}
+	if _, err := ContinueBlockchain(typo); !errors.Is(err, ErrUnknownNetwork) {
+		t.Fatalf("ContinueBlockchain returned %v, want %v", err, ErrUnknownNetwork)
+	}
+}
@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"time"
+)
+
+// NetworkParams are the consensus rules a chain is built under
+type NetworkParams struct {
+	Name string
+	// AddressVersion starts the addresses of the network so coins cannot be
+	// sent to another network by mistake
+	AddressVersion byte
+	// TargetBlockTime is the average time between blocks retargeting aims for
+	TargetBlockTime time.Duration
+	// RetargetInterval is the number of blocks between difficulty changes,
+	// 0 keeps the difficulty at InitialBits forever
+	RetargetInterval int
+	// InitialBits is the difficulty of the genesis block, in leading zero bits
+	InitialBits int
+	MinBits     int
+	MaxBits     int
+	// MaxAdjustBits clamps a single retarget, 2 bits is a factor of 4
+	MaxAdjustBits int
+	// InitialSubsidy is the coinbase reward of the first blocks, it halves
+	// every HalvingInterval blocks, 0 never halves it
+	InitialSubsidy  int
+	HalvingInterval int
+}
+
+var (
+	MainNetParams = NetworkParams{
+		Name:             "main",
+		AddressVersion:   0x00,
+		TargetBlockTime:  10 * time.Second,
+		RetargetInterval: 20,
+		InitialBits:      14,
+		MinBits:          8,
+		MaxBits:          64,
+		MaxAdjustBits:    2,
+		InitialSubsidy:   100,
+		HalvingInterval:  210000,
+	}
+	TestNetParams = NetworkParams{
+		Name:             "test",
+		AddressVersion:   0x6f,
+		TargetBlockTime:  5 * time.Second,
+		RetargetInterval: 10,
+		InitialBits:      12,
+		MinBits:          8,
+		MaxBits:          64,
+		MaxAdjustBits:    2,
+		InitialSubsidy:   100,
+		HalvingInterval:  2000,
+	}
+	RegTestParams = NetworkParams{
+		Name:             "regtest",
+		AddressVersion:   0x3c,
+		TargetBlockTime:  time.Second,
+		RetargetInterval: 0,
+		InitialBits:      8,
+		MinBits:          1,
+		MaxBits:          64,
+		MaxAdjustBits:    2,
+		InitialSubsidy:   100,
+		HalvingInterval:  150,
+	}
+)
+
+var networks = map[string]*NetworkParams{
+	MainNetParams.Name: &MainNetParams,
+	TestNetParams.Name: &TestNetParams,
+	RegTestParams.Name: &RegTestParams,
+}
+
+// ParamsByName resolves a network name, the empty name is the main network
+func ParamsByName(name string) (*NetworkParams, bool) {
+	if name == "" {
+		return &MainNetParams, true
+	}
+	params, ok := networks[name]
+	return params, ok
+}
+
+// Subsidy is the new coins a block at height may pay itself on top of fees
+func (params *NetworkParams) Subsidy(height int) int {
+	if params.HalvingInterval <= 0 {
+		return params.InitialSubsidy
+	}
+	halvings := height / params.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return params.InitialSubsidy >> uint(halvings)
+}
+
+// MaxSupply is the number of coins that will ever be issued, -1 when the
+// subsidy never halves
+func (params *NetworkParams) MaxSupply() int {
+	if params.HalvingInterval <= 0 {
+		return -1
+	}
+	supply := 0
+	for subsidy := params.InitialSubsidy; subsidy > 0; subsidy >>= 1 {
+		supply += subsidy * params.HalvingInterval
+	}
+	return supply
+}
+
+// NextBits is the difficulty required from the child of parent
+func (chain *Blockchain) NextBits(parent *BlockIndex) (int, error) {
+	params := chain.Params()
+	height := parent.Height + 1
+	if params.RetargetInterval <= 0 || height%params.RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+	first := parent
+	for i := 0; i < params.RetargetInterval && first.Height > 0; i++ {
+		var err error
+		if first, err = chain.GetBlockIndex(first.PrevHash); err != nil {
+			return 0, err
+		}
+	}
+	expected := time.Duration(parent.Height-first.Height) * params.TargetBlockTime
+	actual := time.Duration(parent.Timestamp-first.Timestamp) * time.Second
+	return retarget(params, parent.Bits, expected, actual), nil
+}
+
+// retarget adds a bit of difficulty for every time blocks came twice as fast
+// as expected and removes one for every time they came twice as slow
+func retarget(params *NetworkParams, bits int, expected, actual time.Duration) int {
+	if actual < time.Second {
+		actual = time.Second
+	}
+	adjust := 0
+	for adjust < params.MaxAdjustBits && actual*2 <= expected {
+		actual *= 2
+		adjust++
+	}
+	for adjust > -params.MaxAdjustBits && actual >= expected*2 {
+		expected *= 2
+		adjust--
+	}
+	bits += adjust
+	if bits < params.MinBits {
+		bits = params.MinBits
+	}
+	if bits > params.MaxBits {
+		bits = params.MaxBits
+	}
+	return bits
+}
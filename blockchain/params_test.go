@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// storeIndexes stores a branch of block indexes from height 0, one per
+// timestamp, and returns its last index
+func storeIndexes(t *testing.T, chain *Blockchain, bits int, timestamps []int64) *BlockIndex {
+	t.Helper()
+	var last *BlockIndex
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		prevHash := []byte{}
+		for height, timestamp := range timestamps {
+			hash := sha256.Sum256([]byte(fmt.Sprintf("%s %d", t.Name(), height)))
+			last = &BlockIndex{hash[:], prevHash, height, bits, timestamp, nil}
+			if err := txn.Set(indexKey(last.Hash), last.Serialize()); err != nil {
+				return err
+			}
+			prevHash = last.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return last
+}
+
+// every spaces n+1 timestamps seconds apart from 1000
+func every(seconds int64, n int) []int64 {
+	timestamps := []int64{1000}
+	for i := 0; i < n; i++ {
+		timestamps = append(timestamps, timestamps[i]+seconds)
+	}
+	return timestamps
+}
+
+func TestNextBits(t *testing.T) {
+	params := RegTestParams
+	params.TargetBlockTime = 10 * time.Second
+	params.RetargetInterval = 4
+	params.MinBits, params.MaxBits, params.MaxAdjustBits = 6, 10, 2
+	chain, _ := newTestChain(t, Options{Params: &params})
+	for _, tt := range []struct {
+		name       string
+		bits       int
+		timestamps []int64
+		want       int
+	}{
+		{"between retargets", 8, every(1, 2), 8},
+		// the first window starts at genesis and spans 3 block times
+		{"first window on schedule", 8, every(10, 3), 8},
+		{"first window twice as fast", 8, every(5, 3), 9},
+		{"first window twice as slow", 8, every(20, 3), 7},
+		{"second window on schedule", 8, every(10, 7), 8},
+		{"second window twice as fast", 8, every(5, 7), 9},
+		{"fast window clamped to 2 bits", 7, every(1, 7), 9},
+		{"slow window clamped to 2 bits", 9, every(1000, 7), 7},
+		{"same timestamps", 7, every(0, 7), 9},
+		{"timestamps going back", 7, every(-10, 7), 9},
+		{"capped at MaxBits", 9, every(1, 7), 10},
+		{"floored at MinBits", 7, every(1000, 7), 6},
+		{"genesis timestamp only", 8, every(10, 0), 8},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := storeIndexes(t, chain, tt.bits, tt.timestamps)
+			bits, err := chain.NextBits(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bits != tt.want {
+				t.Errorf("bits %d, want %d", bits, tt.want)
+			}
+		})
+	}
+}
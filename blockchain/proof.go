@@ -2,18 +2,18 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
 	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Difficulty
-// in real life this should be dynamic
-const Difficulty = 14
-
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
@@ -21,40 +21,142 @@ type ProofOfWork struct {
 
 func NewProof(b *Block) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+	target.Lsh(target, uint(256-b.Bits))
 	pow := &ProofOfWork{b, target}
 	return pow
 }
 
 func (pow *ProofOfWork) InitData(nonce int) []byte {
+	header := pow.Block.BlockHeader
 	data := bytes.Join(
 		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransaction(),
+			ToHex(int64(header.Version)),
+			header.PrevHash,
+			header.MerkleRoot,
+			ToHex(header.Timestamp),
+			ToHex(int64(header.Bits)),
 			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
+			ToHex(int64(header.Height)),
 		},
 		[]byte{})
 	return data
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
-	nonce := 0
-	for nonce < math.MaxInt {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		intHash.SetBytes(hash[:])
-		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
+// MineOptions tunes Mine, the zero value mines silently on every CPU
+type MineOptions struct {
+	Workers int
+	// Progress is called every ProgressInterval and once more when mining
+	// stops
+	Progress         func(MineStats)
+	ProgressInterval time.Duration
+}
+
+type MineStats struct {
+	Hashes  uint64
+	Elapsed time.Duration
+}
+
+func (stats MineStats) Hashrate() float64 {
+	if stats.Elapsed <= 0 {
+		return 0
+	}
+	return float64(stats.Hashes) / stats.Elapsed.Seconds()
+}
+
+const (
+	hashBatch               = 1024
+	defaultProgressInterval = time.Second
+)
+
+// Mine searches the nonce space with several workers, worker i trying the
+// nonces i, i+workers, i+2*workers... It stops early with the context error
+// when ctx is cancelled
+func (pow *ProofOfWork) Mine(ctx context.Context, opts MineOptions) (int, []byte, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		nonce int
+		hash  []byte
+	}
+	found := make(chan result, workers)
+	var hashes uint64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(nonce int) {
+			defer wg.Done()
+			var intHash big.Int
+			// hashes are counted in batches once tried, the last batch
+			// as far as it got
+			var tried uint64
+			defer func() { atomic.AddUint64(&hashes, tried) }()
+			for {
+				if tried == hashBatch {
+					atomic.AddUint64(&hashes, tried)
+					tried = 0
+				}
+				if tried == 0 && ctx.Err() != nil {
+					return
+				}
+				hash := sha256.Sum256(pow.InitData(nonce))
+				tried++
+				intHash.SetBytes(hash[:])
+				if intHash.Cmp(pow.Target) == -1 {
+					found <- result{nonce, hash[:]}
+					return
+				}
+				if nonce > math.MaxInt-workers {
+					return
+				}
+				nonce += workers
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	start := time.Now()
+	report := func() {
+		if opts.Progress != nil {
+			opts.Progress(MineStats{atomic.LoadUint64(&hashes), time.Since(start)})
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-found:
+			cancel()
+			<-done
+			report()
+			return r.nonce, r.hash, nil
+		case <-done:
+			report()
+			select {
+			case r := <-found:
+				return r.nonce, r.hash, nil
+			default:
+			}
+			if err := ctx.Err(); err != nil {
+				return 0, nil, err
+			}
+			return 0, nil, ErrNonceExhausted
+		case <-ticker.C:
+			report()
 		}
 	}
-	fmt.Println("")
-	return nonce, hash[:]
 }
 
 func (pow *ProofOfWork) Validate() bool {
@@ -62,7 +164,13 @@ func (pow *ProofOfWork) Validate() bool {
 	data := pow.InitData(pow.Block.Nonce)
 	hash := sha256.Sum256(data)
 	intHash.SetBytes(hash[:])
-	return intHash.Cmp(pow.Target) == -1
+	return intHash.Cmp(pow.Target) == -1 && bytes.Equal(hash[:], pow.Block.Hash)
+}
+
+// Work is the expected number of hashes needed to meet the target
+func (pow *ProofOfWork) Work() *big.Int {
+	space := new(big.Int).Lsh(big.NewInt(1), 256)
+	return space.Div(space, new(big.Int).Add(pow.Target, big.NewInt(1)))
 }
 
 func ToHex(num int64) []byte {
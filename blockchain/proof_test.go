@@ -0,0 +1,23 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMineCountsHashesTried(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		block := NewBlock([]*Transaction{}, []byte{byte(i)}, 1, 8)
+		var stats MineStats
+		nonce, _, err := NewProof(block).Mine(context.Background(), MineOptions{
+			Workers:  1,
+			Progress: func(s MineStats) { stats = s },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Hashes != uint64(nonce)+1 {
+			t.Fatalf("nonce %d found after %d hashes", nonce, stats.Hashes)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package blockchain
+
+import (
+	"github.com/dgraph-io/badger"
+)
+
+// NewTestChain creates a chain for tests whose genesis block pays address.
+// Unless opts says otherwise it runs the regtest network with a quiet
+// database, in memory when opts has no DataDir
+func NewTestChain(opts Options, address string) (*Blockchain, error) {
+	if opts.Network == "" && opts.Params == nil {
+		opts.Network = RegTestParams.Name
+	}
+	if opts.Badger == nil {
+		bopts := badger.DefaultOptions("").WithLogger(nil)
+		opts.Badger = &bopts
+	}
+	if opts.DataDir == "" {
+		opts.InMemory = true
+	}
+	return InitBlockchain(opts, address)
+}
@@ -3,15 +3,14 @@ package blockchain
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"github.com/nd-sin/blockchain/wallet"
+	"io/ioutil"
 	"log"
-	"math/big"
 	"strings"
 )
 
@@ -21,6 +20,15 @@ type Transaction struct {
 	Outputs []TxOutput
 }
 
+// IDs hash the gob encoding, which numbers types in the order a process
+// first uses them. Encoding a transaction before anything else makes every
+// process number them alike, whatever it decodes before hashing one
+func init() {
+	if err := gob.NewEncoder(ioutil.Discard).Encode(Transaction{}); err != nil {
+		log.Panic(err)
+	}
+}
+
 func (tx Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
 	enc := gob.NewEncoder(&encoded)
@@ -31,6 +39,15 @@ func (tx Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 	txCopy := *tx
@@ -39,6 +56,17 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
+// idHash recomputes the ID, which commits to everything but the signatures
+// since transactions are signed after their ID is set
+func (tx *Transaction) idHash() []byte {
+	txCopy := *tx
+	txCopy.Inputs = make([]TxInput, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		txCopy.Inputs[i] = TxInput{in.ID, in.Out, nil, in.PubKey}
+	}
+	return txCopy.Hash()
+}
+
 func (tx *Transaction) SetID() {
 	var encoded bytes.Buffer
 	var hash [32]byte
@@ -51,77 +79,114 @@ func (tx *Transaction) SetID() {
 	tx.ID = hash[:]
 }
 
-func CoinbaseTx(to, data string) *Transaction {
+// CoinbaseTx pays the block reward, the subsidy of the block height plus the
+// fees of the block transactions
+func CoinbaseTx(to, data string, reward int, params *NetworkParams) (*Transaction, error) {
 	if data == "" {
-		data = fmt.Sprintf("Coins to %s", to)
+		// coinbases paying the same address must still get distinct IDs
+		randData := make([]byte, 24)
+		if _, err := rand.Read(randData); err != nil {
+			return nil, err
+		}
+		data = fmt.Sprintf("%x", randData)
 	}
 	txIn := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txOut := NewTXOutput(100, to)
+	txOut, err := NewTXOutput(reward, to, params)
+	if err != nil {
+		return nil, err
+	}
 	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
 	tx.SetID()
-	return &tx
+	return &tx, nil
+}
+
+// NewTransaction sends amount to an address, leaving fee to the miner
+func NewTransaction(w *wallet.Wallet, to string, amount, fee int, u *UTXOSet) (*Transaction, error) {
+	unspent, err := u.FindUnspentOutputs(wallet.PublicKeyHash(w.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	return SpendOutputs(w, to, amount, fee, unspent, u.Blockchain.Params())
 }
 
-func NewTransaction(from, to string, amount int, u *UTXOSet) *Transaction {
+// SpendOutputs builds and signs a transaction from unspent outputs of the
+// wallet, they need not come from a local chain
+func SpendOutputs(w *wallet.Wallet, to string, amount, fee int, unspent []UnspentOutput, params *NetworkParams) (*Transaction, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
-	wallets, err := wallet.CreateWallets()
-	if err != nil {
-		log.Panic(err)
+	if fee < 0 {
+		return nil, fmt.Errorf("%w: negative fee", ErrInvalidValue)
 	}
-	w := wallets.GetWallets(from)
+	from := string(w.NetworkAddress(params.AddressVersion))
 	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	acc, validOutputs := u.FindSpendableOutputs(pubKeyHash, amount)
-	if acc < amount {
-		log.Panic("Error: not enough fund")
-	}
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		if err != nil {
-			log.Panic(err)
+	acc := 0
+	for _, out := range unspent {
+		if acc >= amount+fee {
+			break
 		}
-		for _, out := range outs {
-			input := TxInput{txID, out, nil, w.PublicKey}
-			inputs = append(inputs, input)
+		if out.IsLockedWithKey(pubKeyHash) {
+			acc += out.Value
+			inputs = append(inputs, TxInput{out.TxID, out.Index, nil, w.PublicKey})
 		}
 	}
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if acc < amount+fee {
+		return nil, ErrInsufficientFunds
+	}
+	out, err := NewTXOutput(amount, to, params)
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, *out)
+	if acc > amount+fee {
+		change, err := NewTXOutput(acc-amount-fee, from, params)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
 	}
 	tx := Transaction{nil, inputs, outputs}
 	tx.ID = tx.Hash()
-	u.Blockchain.SignTransaction(&tx, w.PrivateKey)
-	return &tx
+	// every input spends an output locked to the wallet
+	if err := tx.sign(w.PrivateKey, func(TxInput) []byte { return pubKeyHash }); err != nil {
+		return nil, err
+	}
+	return &tx, nil
 }
 
 func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
-func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 	for _, in := range tx.Inputs {
 		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+			return ErrInvalidPrevTx
 		}
 	}
+	return tx.sign(privateKey, func(in TxInput) []byte {
+		return prevTXs[hex.EncodeToString(in.ID)].Outputs[in.Out].PubKeyHash
+	})
+}
+
+// sign signs every input, lock returns the hash the output spent by an input
+// is locked to
+func (tx *Transaction) sign(privateKey ecdsa.PrivateKey, lock func(TxInput) []byte) error {
 	txCopy := tx.TrimmedCopy()
 	for inId, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
 		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
+		txCopy.Inputs[inId].PubKey = lock(in)
 		txCopy.ID = txCopy.Hash()
 		txCopy.Inputs[inId].PubKey = nil
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, txCopy.ID)
+		signature, err := wallet.Sign(privateKey, txCopy.ID)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 		tx.Inputs[inId].Signature = signature
 	}
+	return nil
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
@@ -137,39 +202,36 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	return txCopy
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) (bool, error) {
 	if tx.IsCoinbase() {
-		return true
+		return true, nil
 	}
 	for _, in := range tx.Inputs {
 		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction does not exist")
+			return false, ErrInvalidPrevTx
 		}
 	}
 	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
 	for inId, in := range tx.Inputs {
 		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return false, nil
+		}
+		// the signature only counts from the key the output is locked to
+		if !in.UsesKey(prevTX.Outputs[in.Out].PubKeyHash) {
+			return false, nil
+		}
 		txCopy.Inputs[inId].Signature = nil
 		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
 		txCopy.ID = txCopy.Hash()
 		txCopy.Inputs[inId].PubKey = nil
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		x.SetBytes(in.PubKey[(keyLen / 2):])
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
-			return false
+		// a key or signature that does not decode is simply not valid
+		valid, err := wallet.VerifySignature(in.PubKey, txCopy.ID, in.Signature)
+		if err != nil || !valid {
+			return false, nil
 		}
 	}
-	return true
+	return true, nil
 }
 
 func (tx Transaction) String() string {
@@ -0,0 +1,57 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+func TestTransactionSignVerify(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		from, to := wallet.MakeWallet(), wallet.MakeWallet()
+		prevTX := Transaction{nil, []TxInput{{[]byte{}, -1, nil, []byte("coinbase")}}, []TxOutput{
+			{10, wallet.PublicKeyHash(to.PublicKey)},
+			{90, wallet.PublicKeyHash(from.PublicKey)},
+		}}
+		prevTX.SetID()
+		prevTXs := map[string]Transaction{hex.EncodeToString(prevTX.ID): prevTX}
+
+		tx := Transaction{nil, []TxInput{{prevTX.ID, 1, nil, from.PublicKey}}, []TxOutput{
+			{60, wallet.PublicKeyHash(to.PublicKey)},
+			{30, wallet.PublicKeyHash(from.PublicKey)},
+		}}
+		tx.ID = tx.Hash()
+		if err := tx.Sign(from.PrivateKey, prevTXs); err != nil {
+			t.Fatal(err)
+		}
+		if valid, err := tx.Verify(prevTXs); err != nil || !valid {
+			t.Fatalf("signed transaction %x does not verify: %v", tx.ID, err)
+		}
+
+		tampered := tx
+		tampered.Outputs = append([]TxOutput{}, tx.Outputs...)
+		tampered.Outputs[0].Value++
+		if valid, _ := tampered.Verify(prevTXs); valid {
+			t.Fatalf("transaction %x verifies with a changed output", tx.ID)
+		}
+
+		forged := tx
+		forged.Inputs = []TxInput{{prevTX.ID, 1, nil, from.PublicKey}}
+		if err := forged.Sign(to.PrivateKey, prevTXs); err != nil {
+			t.Fatal(err)
+		}
+		if valid, _ := forged.Verify(prevTXs); valid {
+			t.Fatalf("transaction %x verifies signed by another key", tx.ID)
+		}
+
+		stolen := tx
+		stolen.Inputs = []TxInput{{prevTX.ID, 1, nil, to.PublicKey}}
+		if err := stolen.Sign(to.PrivateKey, prevTXs); err != nil {
+			t.Fatal(err)
+		}
+		if valid, _ := stolen.Verify(prevTXs); valid {
+			t.Fatalf("transaction %x verifies spending an output of another key", tx.ID)
+		}
+	}
+}
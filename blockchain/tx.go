@@ -3,6 +3,7 @@ package blockchain
 import (
 	"bytes"
 	"encoding/gob"
+	"fmt"
 	"github.com/nd-sin/blockchain/wallet"
 	"log"
 )
@@ -14,6 +15,9 @@ type TxOutput struct {
 
 type TxOutputs struct {
 	Outputs []TxOutput
+	// Indexes holds the position of every output in its transaction, spent
+	// outputs are dropped from the set so positions no longer line up
+	Indexes []int
 }
 
 type TxInput struct {
@@ -23,10 +27,36 @@ type TxInput struct {
 	PubKey    []byte
 }
 
-func NewTXOutput(value int, address string) *TxOutput {
+func NewTXOutput(value int, address string, params *NetworkParams) (*TxOutput, error) {
 	txo := &TxOutput{value, nil}
-	txo.Lock([]byte(address))
-	return txo
+	if err := txo.Lock([]byte(address), params); err != nil {
+		return nil, err
+	}
+	return txo, nil
+}
+
+func (outs *TxOutputs) Add(index int, out TxOutput) {
+	for len(outs.Indexes) < len(outs.Outputs) {
+		outs.Indexes = append(outs.Indexes, len(outs.Indexes))
+	}
+	outs.Outputs = append(outs.Outputs, out)
+	outs.Indexes = append(outs.Indexes, index)
+}
+
+func (outs TxOutputs) Index(i int) int {
+	if i < len(outs.Indexes) {
+		return outs.Indexes[i]
+	}
+	return i
+}
+
+func (outs TxOutputs) Find(index int) (TxOutput, bool) {
+	for i, out := range outs.Outputs {
+		if outs.Index(i) == index {
+			return out, true
+		}
+	}
+	return TxOutput{}, false
 }
 
 func (outs TxOutputs) Serialize() []byte {
@@ -39,14 +69,11 @@ func (outs TxOutputs) Serialize() []byte {
 	return buffer.Bytes()
 }
 
-func DeserializeOutputs(data []byte) TxOutputs {
+func DeserializeOutputs(data []byte) (TxOutputs, error) {
 	var outputs TxOutputs
 	decode := gob.NewDecoder(bytes.NewReader(data))
 	err := decode.Decode(&outputs)
-	if err != nil {
-		log.Panic(err)
-	}
-	return outputs
+	return outputs, err
 }
 
 func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
@@ -54,10 +81,18 @@ func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
 
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+// Lock pays the output to an address, which must belong to the network of
+// params
+func (out *TxOutput) Lock(address []byte, params *NetworkParams) error {
+	if err := wallet.CheckAddress(string(address), params.AddressVersion); err != nil {
+		return fmt.Errorf("%w: %s", err, address)
+	}
+	pubKeyHash, err := wallet.AddressPubKeyHash(string(address))
+	if err != nil {
+		return err
+	}
 	out.PubKeyHash = pubKeyHash
+	return nil
 }
 
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
@@ -0,0 +1,140 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/dgraph-io/badger"
+	"log"
+)
+
+var (
+	txIndexPrefix = []byte("tx-")
+	// txIndexKey marks databases whose transaction index is complete, the
+	// index is kept up to date from then on
+	txIndexKey = []byte("txindex")
+)
+
+// TxLocation is where a best chain transaction is stored, the block and the
+// position of the transaction in it
+type TxLocation struct {
+	BlockHash []byte
+	Index     int
+}
+
+func (loc TxLocation) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(loc)
+	if err != nil {
+		log.Panic(err)
+	}
+	return res.Bytes()
+}
+
+func DeserializeTxLocation(data []byte) (*TxLocation, error) {
+	var loc TxLocation
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&loc); err != nil {
+		return nil, err
+	}
+	return &loc, nil
+}
+
+func txIndexEntry(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+func hasTxIndex(db *badger.DB) (bool, error) {
+	err := db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(txIndexKey)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func indexBlockTransactions(txn *badger.Txn, block *Block) error {
+	for i, tx := range block.Transactions {
+		if err := txn.Set(txIndexEntry(tx.ID), TxLocation{block.Hash, i}.Serialize()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexBlockTransactions(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if err := txn.Delete(txIndexEntry(tx.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TxIndexed tells whether FindTransaction is served by the transaction index
+func (chain *Blockchain) TxIndexed() bool {
+	return chain.txIndex
+}
+
+// ReindexTransactions builds the transaction index of the best chain from
+// scratch and keeps it up to date from then on
+func (chain *Blockchain) ReindexTransactions() error {
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(txIndexKey)
+	})
+	if err != nil {
+		return err
+	}
+	chain.txIndex = false
+	UTXOSet := UTXOSet{chain}
+	if err := UTXOSet.DeleteByPrefix(txIndexPrefix); err != nil {
+		return err
+	}
+	iter := chain.ForwardIterator(0)
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			break
+		}
+		err = chain.Database.Update(func(txn *badger.Txn) error {
+			return indexBlockTransactions(txn, block)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Set(txIndexKey, []byte{1})
+	})
+	if err != nil {
+		return err
+	}
+	chain.txIndex = true
+	return nil
+}
+
+// locateTransaction reads the index entry of a transaction
+func (chain *Blockchain) locateTransaction(ID []byte) (*TxLocation, error) {
+	var loc *TxLocation
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(txIndexEntry(ID))
+		if err == badger.ErrKeyNotFound {
+			return ErrTxNotFound
+		}
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		loc, err = DeserializeTxLocation(value)
+		return err
+	})
+	return loc, err
+}
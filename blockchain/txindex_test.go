@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// checkLocations looks up the transactions of blocks, they are found where
+// they are stored when their block is on the best chain and not otherwise
+func checkLocations(t *testing.T, chain *Blockchain, blocks map[*Block]bool) {
+	t.Helper()
+	for block, best := range blocks {
+		for i, tx := range block.Transactions {
+			loc, err := chain.locateTransaction(tx.ID)
+			found, findErr := chain.FindTransactionBlock(tx.ID)
+			if !best {
+				if !errors.Is(err, ErrTxNotFound) || !errors.Is(findErr, ErrTxNotFound) {
+					t.Errorf("transaction %x of block %x off the best chain: located %v, %v, found %v", tx.ID, block.Hash, loc, err, findErr)
+				}
+				continue
+			}
+			if err != nil || !bytes.Equal(loc.BlockHash, block.Hash) || loc.Index != i {
+				t.Errorf("transaction %x located at %+v, error %v, want %d in %x", tx.ID, loc, err, i, block.Hash)
+			}
+			if findErr != nil || !bytes.Equal(found.Hash, block.Hash) {
+				t.Errorf("transaction %x found in %v, error %v, want %x", tx.ID, found, findErr, block.Hash)
+			}
+		}
+	}
+}
+
+// TestTxIndexFollowsReorgs switches to a fork and back to a longer main
+// chain, and rebuilds the index in between
+func TestTxIndexFollowsReorgs(t *testing.T) {
+	chain, _, a, b, main, fork := forkedChain(t, Options{TxIndex: true})
+	checkLocations(t, chain, map[*Block]bool{main: true, fork: false})
+
+	fork2 := mineOn(t, chain, fork.Hash, b)
+	accept(t, chain, fork2)
+	after := map[*Block]bool{main: false, fork: true, fork2: true}
+	checkLocations(t, chain, after)
+	if err := chain.ReindexTransactions(); err != nil {
+		t.Fatal(err)
+	}
+	if !chain.TxIndexed() {
+		t.Fatal("index not marked complete after ReindexTransactions")
+	}
+	checkLocations(t, chain, after)
+
+	main2 := mineOn(t, chain, main.Hash, a)
+	accept(t, chain, main2)
+	main3 := mineOn(t, chain, main2.Hash, a)
+	accept(t, chain, main3)
+	checkLocations(t, chain, map[*Block]bool{main: true, main2: true, main3: true, fork: false, fork2: false})
+}
@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/dgraph-io/badger"
+	"log"
+	"sort"
+)
+
+var undoPrefix = []byte("undo-")
+
+type SpentOutput struct {
+	TxID   []byte
+	Index  int
+	Output TxOutput
+}
+
+// BlockUndo lists the outputs a block spent, in the order its inputs spent
+// them
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+func (undo BlockUndo) Serialize() []byte {
+	var res bytes.Buffer
+	encoder := gob.NewEncoder(&res)
+	err := encoder.Encode(undo)
+	if err != nil {
+		log.Panic(err)
+	}
+	return res.Bytes()
+}
+
+func DeserializeUndo(data []byte) (*BlockUndo, error) {
+	var undo BlockUndo
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&undo); err != nil {
+		return nil, err
+	}
+	return &undo, nil
+}
+
+func undoKey(hash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), hash...)
+}
+
+// Disconnect reverts Update for the tip block, it removes the outputs the
+// block created and restores the ones it spent in a single database
+// transaction
+func (u *UTXOSet) Disconnect(block *Block) error {
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		return u.disconnect(txn, block)
+	})
+}
+
+func (u *UTXOSet) disconnect(txn *badger.Txn, block *Block) error {
+	item, err := txn.Get(undoKey(block.Hash))
+	if err == badger.ErrKeyNotFound {
+		return ErrNoUndoData
+	}
+	if err != nil {
+		return err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	undo, err := DeserializeUndo(value)
+	if err != nil {
+		return err
+	}
+	spent := undo.Spent
+	// walk the block backwards so outputs created and spent inside it are
+	// restored before their transaction is removed
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		if err := txn.Delete(utxoKey(tx.ID)); err != nil {
+			return err
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		if len(spent) < len(tx.Inputs) {
+			return ErrNoUndoData
+		}
+		restore := spent[len(spent)-len(tx.Inputs):]
+		spent = spent[:len(spent)-len(tx.Inputs)]
+		for _, so := range restore {
+			key := utxoKey(so.TxID)
+			outs, err := getOutputs(txn, key)
+			if err != nil {
+				return err
+			}
+			outs.Add(so.Index, so.Output)
+			sort.Sort(byIndex(outs))
+			if err := putOutputs(txn, key, outs); err != nil {
+				return err
+			}
+		}
+	}
+	if u.Blockchain.addrIndex {
+		if err := unindexAddresses(txn, block); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(undoKey(block.Hash))
+}
+
+type byIndex TxOutputs
+
+func (outs byIndex) Len() int {
+	return len(outs.Outputs)
+}
+
+func (outs byIndex) Less(i, j int) bool {
+	return TxOutputs(outs).Index(i) < TxOutputs(outs).Index(j)
+}
+
+func (outs byIndex) Swap(i, j int) {
+	outs.Outputs[i], outs.Outputs[j] = outs.Outputs[j], outs.Outputs[i]
+	outs.Indexes[i], outs.Indexes[j] = outs.Indexes[j], outs.Indexes[i]
+}
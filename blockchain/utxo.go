@@ -3,8 +3,8 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"github.com/dgraph-io/badger"
-	"log"
 )
 
 var (
@@ -16,7 +16,15 @@ type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+// UnspentOutput is an output of the set along with the outpoint spending it
+// refers to
+type UnspentOutput struct {
+	TxID  []byte
+	Index int
+	TxOutput
+}
+
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspentOuts := make(map[string][]int)
 	accumulated := 0
 	db := u.Blockchain.Database
@@ -27,33 +35,32 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
 			item := it.Item()
 			k := item.Key()
-			var value []byte
-			err := item.Value(func(val []byte) error {
-				value = val
-				return nil
-			})
+			value, err := item.ValueCopy(nil)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 			k = bytes.TrimPrefix(k, utxoPrefix)
 			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(value)
-			for outIdx, out := range outs.Outputs {
+			outs, err := DeserializeOutputs(value)
+			if err != nil {
+				return err
+			}
+			for i, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
 					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+					unspentOuts[txID] = append(unspentOuts[txID], outs.Index(i))
 				}
 			}
 		}
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return 0, nil, err
 	}
-	return accumulated, unspentOuts
+	return accumulated, unspentOuts, nil
 }
 
-func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
+func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) ([]TxOutput, error) {
 	var UTXOs []TxOutput
 	db := u.Blockchain.Database
 	err := db.View(func(txn *badger.Txn) error {
@@ -62,15 +69,14 @@ func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
 		defer it.Close()
 		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
 			item := it.Item()
-			var value []byte
-			err := item.Value(func(val []byte) error {
-				value = val
-				return nil
-			})
+			value, err := item.ValueCopy(nil)
 			if err != nil {
-				log.Panic(err)
+				return err
+			}
+			outs, err := DeserializeOutputs(value)
+			if err != nil {
+				return err
 			}
-			outs := DeserializeOutputs(value)
 			for _, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubKeyHash) {
 					UTXOs = append(UTXOs, out)
@@ -80,12 +86,59 @@ func (u UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return UTXOs
+	return UTXOs, nil
 }
 
-func (u UTXOSet) CountTransactions() int {
+// FindUnspentOutputs returns the unspent outputs locked with pubKeyHash
+func (u UTXOSet) FindUnspentOutputs(pubKeyHash []byte) ([]UnspentOutput, error) {
+	var unspent []UnspentOutput
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			txID := item.KeyCopy(nil)[prefixLength:]
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			outs, err := DeserializeOutputs(value)
+			if err != nil {
+				return err
+			}
+			for i, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					unspent = append(unspent, UnspentOutput{txID, outs.Index(i), out})
+				}
+			}
+		}
+		return nil
+	})
+	return unspent, err
+}
+
+// FindOutput returns an output that is still unspent, ErrOutputSpent is
+// returned when it was spent or never existed
+func (u UTXOSet) FindOutput(txID []byte, index int) (TxOutput, error) {
+	var output TxOutput
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		outs, err := getOutputs(txn, utxoKey(txID))
+		if err != nil {
+			return err
+		}
+		out, ok := outs.Find(index)
+		if !ok {
+			return ErrOutputSpent
+		}
+		output = out
+		return nil
+	})
+	return output, err
+}
+
+func (u UTXOSet) CountTransactions() (int, error) {
 	db := u.Blockchain.Database
 	counter := 0
 	err := db.View(func(txn *badger.Txn) error {
@@ -97,89 +150,111 @@ func (u UTXOSet) CountTransactions() int {
 		}
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
-	}
-	return counter
+	return counter, err
 }
 
-func (u UTXOSet) Reindex() {
+func (u UTXOSet) Reindex() error {
 	db := u.Blockchain.Database
-	u.DeleteByPrefix(utxoPrefix)
-	UTXO := u.Blockchain.FindUTXO()
-	err := db.Update(func(txn *badger.Txn) error {
+	if err := u.DeleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(txn *badger.Txn) error {
 		for txId, outs := range UTXO {
 			key, err := hex.DecodeString(txId)
 			if err != nil {
 				return err
 			}
-			key = append(utxoPrefix, key...)
-			err = txn.Set(key, outs.Serialize())
-			if err != nil {
-				log.Panic(err)
+			if err := txn.Set(utxoKey(key), outs.Serialize()); err != nil {
+				return err
 			}
 		}
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
-	}
 }
 
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					if err != nil {
-						log.Panic(err)
-					}
-					var value []byte
-					err = item.Value(func(val []byte) error {
-						value = val
-						return nil
-					})
-					if err != nil {
-						log.Panic(err)
-					}
-					outs := DeserializeOutputs(value)
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
+// Update applies a block to the set and records the outputs it spends as the
+// block's undo data in the same database transaction
+func (u *UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		return u.update(txn, block)
+	})
+}
+
+func (u *UTXOSet) update(txn *badger.Txn, block *Block) error {
+	undo := BlockUndo{}
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.Inputs {
+				updatedOuts := TxOutputs{}
+				inID := utxoKey(in.ID)
+				outs, err := getOutputs(txn, inID)
+				if err != nil {
+					return err
+				}
+				spent, ok := outs.Find(in.Out)
+				if !ok {
+					return fmt.Errorf("%w: %x:%d", ErrOutputSpent, in.ID, in.Out)
+				}
+				undo.Spent = append(undo.Spent, SpentOutput{in.ID, in.Out, spent})
+				for i, out := range outs.Outputs {
+					if outs.Index(i) != in.Out {
+						updatedOuts.Add(outs.Index(i), out)
 					}
 				}
-			}
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
+				if err := putOutputs(txn, inID, updatedOuts); err != nil {
+					return err
+				}
 			}
 		}
-		return nil
-	})
+		newOutputs := TxOutputs{}
+		for outIdx, out := range tx.Outputs {
+			newOutputs.Add(outIdx, out)
+		}
+		if err := txn.Set(utxoKey(tx.ID), newOutputs.Serialize()); err != nil {
+			return err
+		}
+	}
+	if u.Blockchain.addrIndex {
+		if err := indexAddresses(txn, block, undo.Spent); err != nil {
+			return err
+		}
+	}
+	return txn.Set(undoKey(block.Hash), undo.Serialize())
+}
+
+func utxoKey(txID []byte) []byte {
+	return append(append([]byte{}, utxoPrefix...), txID...)
+}
+
+func getOutputs(txn *badger.Txn, key []byte) (TxOutputs, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return TxOutputs{}, nil
+	}
 	if err != nil {
-		log.Panic(err)
+		return TxOutputs{}, err
 	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return TxOutputs{}, err
+	}
+	return DeserializeOutputs(value)
 }
 
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
+// putOutputs stores the outputs left for a transaction, deleting the entry
+// once all of them are spent
+func putOutputs(txn *badger.Txn, key []byte, outs TxOutputs) error {
+	if len(outs.Outputs) == 0 {
+		return txn.Delete(key)
+	}
+	return txn.Set(key, outs.Serialize())
+}
+
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) error {
 	deleteKeys := func(keysForDelete [][]byte) error {
 		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
 			for _, key := range keysForDelete {
@@ -194,7 +269,7 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 		return nil
 	}
 	collectSize := 100000
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
+	return u.Blockchain.Database.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
@@ -207,7 +282,7 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 			keysCollected++
 			if keysCollected == collectSize {
 				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
+					return err
 				}
 				keysForDelete = make([][]byte, 0, collectSize)
 				keysCollected = 0
@@ -215,7 +290,7 @@ func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
 		}
 		if keysCollected > 0 {
 			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
 		return nil
@@ -0,0 +1,213 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+const maxFutureBlockTime = 2 * time.Hour
+
+// MaxMoney bounds every value and every sum of values, it is above the
+// supply of every network and far enough from the int limits that adding two
+// amounts cannot overflow
+const MaxMoney = 1000000000
+
+// addValue adds an amount to a sum of amounts, both must stay within MaxMoney
+func addValue(sum, value int) (int, error) {
+	if value < 0 || value > MaxMoney || sum > MaxMoney-value {
+		return 0, fmt.Errorf("%w: %d added to %d", ErrValueOutOfRange, value, sum)
+	}
+	return sum + value, nil
+}
+
+// CheckBlock runs the checks that need nothing but the block itself and the
+// consensus engine
+func (chain *Blockchain) CheckBlock(block *Block) error {
+	if block.Version < 1 || block.Version > BlockVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrBadHeader, block.Version)
+	}
+	if time.Unix(block.Timestamp, 0).After(time.Now().Add(maxFutureBlockTime)) {
+		return fmt.Errorf("%w: timestamp %d is too far in the future", ErrBadHeader, block.Timestamp)
+	}
+	if err := chain.engine.VerifySeal(block); err != nil {
+		return err
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransaction()) {
+		return fmt.Errorf("%w: block %x", ErrBadMerkleRoot, block.Hash)
+	}
+	return checkBlockTransactions(block)
+}
+
+// ValidateBlock fully validates a block about to be connected on top of the
+// current tip, including every transaction against the UTXO set
+func (chain *Blockchain) ValidateBlock(block *Block) error {
+	if err := chain.CheckBlock(block); err != nil {
+		return err
+	}
+	if !bytes.Equal(block.PrevHash, chain.LastHash) {
+		return fmt.Errorf("%w: block %x has parent %x, tip is %x", ErrBadPrevHash, block.Hash, block.PrevHash, chain.LastHash)
+	}
+	tip, err := chain.GetBlockIndex(chain.LastHash)
+	if err != nil {
+		return err
+	}
+	if err := chain.checkHeader(block, tip); err != nil {
+		return err
+	}
+	return chain.checkTransactions(block)
+}
+
+// checkHeader checks the header fields that depend on the parent block
+func (chain *Blockchain) checkHeader(block *Block, parent *BlockIndex) error {
+	if block.Height != parent.Height+1 {
+		return fmt.Errorf("%w: block %x claims height %d, parent is at %d", ErrBadHeader, block.Hash, block.Height, parent.Height)
+	}
+	if block.Timestamp < parent.Timestamp {
+		return fmt.Errorf("%w: block %x is older than its parent", ErrBadHeader, block.Hash)
+	}
+	expected := block.BlockHeader
+	if err := chain.engine.Prepare(chain, &expected, parent); err != nil {
+		return err
+	}
+	if block.Bits != expected.Bits {
+		return fmt.Errorf("%w: block %x has bits %d, expected %d", ErrBadHeader, block.Hash, block.Bits, expected.Bits)
+	}
+	return nil
+}
+
+func checkBlockTransactions(block *Block) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return fmt.Errorf("%w: first transaction must be the coinbase", ErrBadCoinbase)
+	}
+	txIDs := make(map[string]bool)
+	spent := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return fmt.Errorf("%w: transaction %d is a second coinbase", ErrBadCoinbase, i)
+		}
+		if err := checkTransaction(tx); err != nil {
+			return err
+		}
+		txID := hex.EncodeToString(tx.ID)
+		if txIDs[txID] {
+			return fmt.Errorf("%w: transaction %x appears twice", ErrBadTransaction, tx.ID)
+		}
+		txIDs[txID] = true
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			key := outpoint(in.ID, in.Out)
+			if spent[key] {
+				return fmt.Errorf("%w: %s spent twice in block %x", ErrDoubleSpend, key, block.Hash)
+			}
+			spent[key] = true
+		}
+	}
+	return nil
+}
+
+// checkTransaction runs the checks that need nothing but the transaction
+// itself, blocks and the mempool both run them
+func checkTransaction(tx *Transaction) error {
+	if !bytes.Equal(tx.ID, tx.idHash()) {
+		return fmt.Errorf("%w: transaction %x", ErrBadTxID, tx.ID)
+	}
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return fmt.Errorf("%w: transaction %x has no inputs or outputs", ErrBadTransaction, tx.ID)
+	}
+	outputs := 0
+	for _, out := range tx.Outputs {
+		if out.Value <= 0 {
+			return fmt.Errorf("%w: transaction %x has a non positive output", ErrInvalidValue, tx.ID)
+		}
+		if len(out.PubKeyHash) != wallet.PubKeyHashLength {
+			return fmt.Errorf("%w: transaction %x pays a malformed key hash", ErrBadTransaction, tx.ID)
+		}
+		var err error
+		if outputs, err = addValue(outputs, out.Value); err != nil {
+			return fmt.Errorf("%w: transaction %x outputs", err, tx.ID)
+		}
+	}
+	return nil
+}
+
+// checkTransactions verifies the block spends only unspent outputs, with
+// valid signatures, without creating value
+func (chain *Blockchain) checkTransactions(block *Block) error {
+	UTXOSet := UTXOSet{chain}
+	created := make(map[string]*Transaction)
+	fees := 0
+	for _, tx := range block.Transactions[1:] {
+		prevTXs := make(map[string]Transaction)
+		inputs := 0
+		for i, in := range tx.Inputs {
+			var out TxOutput
+			inID := hex.EncodeToString(in.ID)
+			if prevTX, ok := created[inID]; ok {
+				if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+					return fmt.Errorf("%w: transaction %x input %d", ErrOutputSpent, tx.ID, i)
+				}
+				out = prevTX.Outputs[in.Out]
+				prevTXs[inID] = *prevTX
+			} else {
+				var err error
+				out, err = UTXOSet.FindOutput(in.ID, in.Out)
+				if err != nil {
+					return fmt.Errorf("%w: transaction %x input %d", err, tx.ID, i)
+				}
+				if _, ok := prevTXs[inID]; !ok {
+					prevTX, err := chain.FindTransaction(in.ID)
+					if err != nil {
+						return err
+					}
+					prevTXs[inID] = prevTX
+				}
+			}
+			if !in.UsesKey(out.PubKeyHash) {
+				return fmt.Errorf("%w: transaction %x input %d uses another key", ErrInvalidSignature, tx.ID, i)
+			}
+			var err error
+			if inputs, err = addValue(inputs, out.Value); err != nil {
+				return fmt.Errorf("%w: transaction %x inputs", err, tx.ID)
+			}
+		}
+		outputs := 0
+		for _, out := range tx.Outputs {
+			var err error
+			if outputs, err = addValue(outputs, out.Value); err != nil {
+				return fmt.Errorf("%w: transaction %x outputs", err, tx.ID)
+			}
+		}
+		if outputs > inputs {
+			return fmt.Errorf("%w: transaction %x spends %d out of %d", ErrInvalidValue, tx.ID, outputs, inputs)
+		}
+		var err error
+		if fees, err = addValue(fees, inputs-outputs); err != nil {
+			return fmt.Errorf("%w: block fees", err)
+		}
+		valid, err := tx.Verify(prevTXs)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return fmt.Errorf("%w: transaction %x", ErrInvalidSignature, tx.ID)
+		}
+		created[hex.EncodeToString(tx.ID)] = tx
+	}
+	reward := 0
+	for _, out := range block.Transactions[0].Outputs {
+		var err error
+		if reward, err = addValue(reward, out.Value); err != nil {
+			return fmt.Errorf("%w: coinbase outputs", err)
+		}
+	}
+	if limit := chain.params.Subsidy(block.Height) + fees; reward > limit {
+		return fmt.Errorf("%w: reward %d exceeds %d", ErrBadCoinbase, reward, limit)
+	}
+	return nil
+}
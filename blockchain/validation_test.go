@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+// newTestChain starts an in-memory regtest chain whose genesis block pays
+// 100 coins to a new wallet, opts picks the indexes
+func newTestChain(t *testing.T, opts Options) (*Blockchain, *wallet.Wallet) {
+	t.Helper()
+	w := wallet.MakeWallet()
+	chain, err := NewTestChain(opts, string(w.NetworkAddress(RegTestParams.AddressVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+	return chain, w
+}
+
+// spendGenesis signs a transaction spending the genesis coinbase into outputs
+// of the given values paying the wallet back
+func spendGenesis(t *testing.T, chain *Blockchain, w *wallet.Wallet, values ...int) *Transaction {
+	t.Helper()
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+	tx := Transaction{nil, []TxInput{{coinbase.ID, 0, nil, w.PublicKey}}, nil}
+	for _, value := range values {
+		tx.Outputs = append(tx.Outputs, TxOutput{value, wallet.PublicKeyHash(w.PublicKey)})
+	}
+	tx.ID = tx.Hash()
+	if err := chain.SignTransaction(&tx, w.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+func TestOutputSumOverflow(t *testing.T) {
+	chain, w := newTestChain(t, Options{})
+	tx := spendGenesis(t, chain, w, 1<<62, 1<<62, 1<<62, 1<<62+50)
+	coinbase, err := CoinbaseTx(string(w.NetworkAddress(RegTestParams.AddressVersion)), "", 100, chain.Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.PrepareBlock([]*Transaction{coinbase, tx})
+	if !errors.Is(err, ErrValueOutOfRange) {
+		t.Fatalf("PrepareBlock returned %v, want %v", err, ErrValueOutOfRange)
+	}
+}
+
+func TestCoinbaseOverMaxMoney(t *testing.T) {
+	chain, w := newTestChain(t, Options{})
+	coinbase, err := CoinbaseTx(string(w.NetworkAddress(RegTestParams.AddressVersion)), "", MaxMoney+1, chain.Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chain.PrepareBlock([]*Transaction{coinbase})
+	if !errors.Is(err, ErrValueOutOfRange) {
+		t.Fatalf("PrepareBlock returned %v, want %v", err, ErrValueOutOfRange)
+	}
+}
+
+func TestMempoolOutputSumOverflow(t *testing.T) {
+	chain, w := newTestChain(t, Options{})
+	tx := spendGenesis(t, chain, w, 1<<62, 1<<62, 1<<62, 1<<62+50)
+	if err := NewMempool(chain).Add(tx); !errors.Is(err, ErrValueOutOfRange) {
+		t.Fatalf("Add returned %v, want %v", err, ErrValueOutOfRange)
+	}
+}
+
+func TestMempoolRejectsMalformed(t *testing.T) {
+	chain, w := newTestChain(t, Options{})
+	mempool := NewMempool(chain)
+	if err := mempool.Add(&Transaction{ID: []byte("bogus")}); !errors.Is(err, ErrBadTxID) {
+		t.Fatalf("Add returned %v, want %v", err, ErrBadTxID)
+	}
+	empty := &Transaction{}
+	empty.ID = empty.idHash()
+	if err := mempool.Add(empty); !errors.Is(err, ErrBadTransaction) {
+		t.Fatalf("Add returned %v, want %v", err, ErrBadTransaction)
+	}
+	tx := spendGenesis(t, chain, w, 60)
+	tx.ID = []byte("bogus")
+	if err := mempool.Add(tx); !errors.Is(err, ErrBadTxID) {
+		t.Fatalf("Add returned %v, want %v", err, ErrBadTxID)
+	}
+	tx = spendGenesis(t, chain, w, 60)
+	tx.Outputs[0].PubKeyHash = tx.Outputs[0].PubKeyHash[:19]
+	tx.ID = tx.idHash()
+	if err := mempool.Add(tx); !errors.Is(err, ErrBadTransaction) {
+		t.Fatalf("Add returned %v, want %v", err, ErrBadTransaction)
+	}
+	if mempool.Count() != 0 {
+		t.Fatalf("mempool holds %d transactions", mempool.Count())
+	}
+}
+
+func TestMempoolRejectsOtherKey(t *testing.T) {
+	chain, w := newTestChain(t, Options{})
+	thief := wallet.MakeWallet()
+	tx := spendGenesis(t, chain, thief, 100)
+	mempool := NewMempool(chain)
+	if err := mempool.Add(tx); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Add returned %v, want %v", err, ErrInvalidSignature)
+	}
+	if err := mempool.Add(spendGenesis(t, chain, w, 100)); err != nil {
+		t.Fatalf("owner spend rejected: %v", err)
+	}
+}
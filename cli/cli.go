@@ -1,26 +1,77 @@
 package cli
 
 import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/nd-sin/blockchain/blockchain"
+	"github.com/nd-sin/blockchain/network"
+	"github.com/nd-sin/blockchain/rpc"
 	"github.com/nd-sin/blockchain/wallet"
+	"golang.org/x/crypto/ssh/terminal"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-type CommandLine struct{}
+// passphraseEnv holds the wallet passphrase when -passphrase is not given,
+// without either it is asked on the terminal
+const passphraseEnv = "BLOCKCHAIN_PASSPHRASE"
+
+type CommandLine struct {
+	dataDir    string
+	network    string
+	passphrase string
+	params     *blockchain.NetworkParams
+}
 
 func (cli *CommandLine) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("blockchain -address ADDRESS - creates a blockchain")
+	fmt.Println("blockchain -address ADDRESS [-consensus pow|poa] [-validators ADDRESS,ADDRESS] - creates a blockchain")
 	fmt.Println("print - Prints the blocks in the chain")
-	fmt.Println("send -from FROM - to TO -amount AMOUNT - Send amount")
+	fmt.Println("send -from FROM - to TO -amount AMOUNT [-fee FEE] [-node ADDR] - Send amount, with -node the node at ADDR supplies the outputs spent and relays the transaction")
+	fmt.Println("mine -address ADDRESS [-blocks N] [-node ADDR] - Mines N blocks paying their rewards to the address, with -node the local node at ADDR mines its pending transactions")
 	fmt.Println("wallet - Creates a new wallet")
+	fmt.Println("wallet changepass [-new-passphrase PASS] - Encrypts the wallet file under a new passphrase")
+	fmt.Println("wallet export -address ADDRESS - Prints the private key of an address")
+	fmt.Println("wallet import -key KEY - Adds the wallet of an exported private key")
+	fmt.Println("wallet mnemonic - Prints the recovery phrase addresses are derived from")
+	fmt.Println("wallet restore -mnemonic PHRASE [-count N] - Derives the first N addresses of a recovery phrase again")
 	fmt.Println("wallets - Lists the addresses")
-	fmt.Println("reindex - Rebuilds the UTXO")
+	fmt.Println("reindex [-txindex] [-addrindex] - Rebuilds the UTXO set, and the transaction or address index with -txindex or -addrindex")
+	fmt.Println("history -address ADDRESS [-skip N] [-count N] - Lists the transactions of an address, newest first")
+	fmt.Println("supply - Prints the coins issued so far and the supply cap")
+	fmt.Println("proof -tx TXID - Prints the merkle inclusion proof of a transaction")
+	fmt.Println("startnode [-listen ADDR] [-peers ADDR,ADDR] [-miner ADDRESS] - Runs a network node")
+	fmt.Println("serve [-rpc ADDR] [-listen ADDR] [-peers ADDR,ADDR] [-miner ADDRESS] - Runs a network node with a JSON-RPC server, event subscriptions on its /ws WebSocket")
+	fmt.Println("Every command accepts -datadir DIR to choose where the chain and wallets are stored")
+	fmt.Println("and -network NAME to choose the network, main by default")
+	fmt.Println("Commands using wallet keys take -passphrase PASS or read " + passphraseEnv + " and prompt otherwise")
+}
+
+func (cli *CommandLine) options() blockchain.Options {
+	opts := blockchain.DefaultOptions()
+	opts.DataDir = cli.dataDir
+	opts.Network = cli.network
+	return opts
+}
+
+func (cli *CommandLine) addressVersion() byte {
+	return cli.params.AddressVersion
+}
+
+// checkAddress makes sure address is well formed and belongs to the network
+// in use
+func (cli *CommandLine) checkAddress(address string) error {
+	return wallet.CheckAddress(address, cli.addressVersion())
 }
 
 func (cli *CommandLine) validateArgs() {
@@ -30,40 +81,280 @@ func (cli *CommandLine) validateArgs() {
 	}
 }
 
-func (cli *CommandLine) reindex() {
-	chain := blockchain.ContinueBlockchain("")
-	defer chain.Database.Close()
+func (cli *CommandLine) reindex(txIndex, addrIndex bool) error {
+	chain, err := blockchain.ContinueBlockchain(cli.options())
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+	if txIndex {
+		if err := chain.ReindexTransactions(); err != nil {
+			return err
+		}
+		fmt.Println("Transaction index rebuilt")
+	}
+	if addrIndex {
+		if err := chain.ReindexAddresses(); err != nil {
+			return err
+		}
+		fmt.Println("Address index rebuilt")
+	}
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	UTXOSet.Reindex()
-	count := UTXOSet.CountTransactions()
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+	return nil
 }
 
-func (cli *CommandLine) listAddresses() {
-	wallets, _ := wallet.CreateWallets()
+// supply adds up the unspent outputs found by walking the chain, fees move
+// coins around and coinbases claiming less than allowed destroy them
+func (cli *CommandLine) supply() error {
+	chain, err := blockchain.ContinueBlockchain(cli.options())
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+	UTXOs, err := chain.FindUTXO()
+	if err != nil {
+		return err
+	}
+	issued := 0
+	for _, outs := range UTXOs {
+		for _, out := range outs.Outputs {
+			issued += out.Value
+		}
+	}
+	height, err := chain.Height()
+	if err != nil {
+		return err
+	}
+	params := chain.Params()
+	fmt.Printf("Height: %d\n", height)
+	fmt.Printf("Issued: %d\n", issued)
+	fmt.Printf("Next subsidy: %d\n", params.Subsidy(height+1))
+	if max := params.MaxSupply(); max >= 0 {
+		fmt.Printf("Max supply: %d\n", max)
+	} else {
+		fmt.Println("Max supply: unlimited")
+	}
+	return nil
+}
+
+// readPassphrase takes the passphrase from -passphrase or the environment,
+// or asks for it once per run
+func (cli *CommandLine) readPassphrase(prompt string) (string, error) {
+	if cli.passphrase != "" {
+		return cli.passphrase, nil
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := promptPassphrase(prompt)
+	if err != nil {
+		return "", err
+	}
+	cli.passphrase = passphrase
+	return passphrase, nil
+}
+
+func promptPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		passphrase, err := terminal.ReadPassword(fd)
+		return string(passphrase), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// unlockWallets loads the wallet file with its keys decrypted
+func (cli *CommandLine) unlockWallets() (*wallet.Wallets, error) {
+	wallets, err := wallet.CreateWallets(cli.options().Dir(), cli.addressVersion())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !wallets.Encrypted() {
+		return wallets, nil
+	}
+	passphrase, err := cli.readPassphrase("Wallet passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	return wallets, wallets.Unlock(passphrase)
+}
+
+func (cli *CommandLine) changePassphrase(newPassphrase string) error {
+	wallets, err := cli.unlockWallets()
+	if err != nil {
+		return err
+	}
+	if newPassphrase == "" {
+		if newPassphrase, err = promptPassphrase("New wallet passphrase: "); err != nil {
+			return err
+		}
+	}
+	if err := wallets.SetPassphrase(newPassphrase); err != nil {
+		return err
+	}
+	if err := wallets.SaveFile(); err != nil {
+		return err
+	}
+	fmt.Println("Passphrase changed")
+	return nil
+}
+
+func (cli *CommandLine) listAddresses() error {
+	wallets, _ := wallet.CreateWallets(cli.options().Dir(), cli.addressVersion())
 	addresses := wallets.GetAllWallets()
 	for _, address := range addresses {
 		fmt.Println(address)
 	}
+	return nil
+}
+
+// writableWallets unlocks the wallet file, asking for the passphrase of
+// files that are not encrypted yet
+func (cli *CommandLine) writableWallets() (*wallet.Wallets, error) {
+	wallets, err := cli.unlockWallets()
+	if err != nil {
+		return nil, err
+	}
+	if !wallets.Encrypted() {
+		passphrase, err := cli.readPassphrase("New wallet passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if err := wallets.SetPassphrase(passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return wallets, nil
 }
 
-func (cli *CommandLine) createWallet() {
-	wallets, _ := wallet.CreateWallets()
-	address := wallets.AddWallet()
-	wallets.SaveFile()
+// createWallet derives the next address, the first one creates the recovery
+// phrase and shows it once
+func (cli *CommandLine) createWallet() error {
+	wallets, err := cli.writableWallets()
+	if err != nil {
+		return err
+	}
+	if !wallets.HasSeed() {
+		mnemonic, err := wallet.NewMnemonic(128)
+		if err != nil {
+			return err
+		}
+		if err := wallets.SetMnemonic(mnemonic); err != nil {
+			return err
+		}
+		fmt.Printf("Write down your recovery phrase, it restores every address of this wallet file:\n%s\n", mnemonic)
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveFile(); err != nil {
+		return err
+	}
 	fmt.Printf("New address is: %s\n", address)
+	return nil
+}
+
+func (cli *CommandLine) exportKey(address string) error {
+	wallets, err := cli.unlockWallets()
+	if err != nil {
+		return err
+	}
+	w, err := wallets.GetWallets(address)
+	if err != nil {
+		return err
+	}
+	fmt.Println(w.ExportKey())
+	return nil
 }
 
-func (cli *CommandLine) printChain() {
-	chain := blockchain.ContinueBlockchain("")
-	defer chain.Database.Close()
+func (cli *CommandLine) importKey(key string) error {
+	wallets, err := cli.writableWallets()
+	if err != nil {
+		return err
+	}
+	address, err := wallets.ImportWallet(key)
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveFile(); err != nil {
+		return err
+	}
+	fmt.Printf("Imported address: %s\n", address)
+	return nil
+}
+
+func (cli *CommandLine) showMnemonic() error {
+	wallets, err := cli.unlockWallets()
+	if err != nil {
+		return err
+	}
+	if !wallets.HasSeed() {
+		return fmt.Errorf("%w: the wallet file has none", wallet.ErrInvalidMnemonic)
+	}
+	fmt.Println(wallets.Mnemonic)
+	return nil
+}
+
+func (cli *CommandLine) restoreWallets(mnemonic string, count int) error {
+	wallets, err := cli.writableWallets()
+	if err != nil {
+		return err
+	}
+	if err := wallets.SetMnemonic(mnemonic); err != nil {
+		return err
+	}
+	addresses, err := wallets.Restore(count)
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveFile(); err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		fmt.Println(address)
+	}
+	return nil
+}
+
+func (cli *CommandLine) printChain() error {
+	chain, err := blockchain.ContinueBlockchain(cli.options())
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
 	iter := chain.Iterator()
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Version: %d\n", block.Version)
+		fmt.Printf("Timestamp: %s\n", time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339))
 		fmt.Printf("Previous Hash: %x\n", block.PrevHash)
 		fmt.Printf("Current Hash: %x\n", block.Hash)
-		pow := blockchain.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("Merkle Root: %x\n", block.MerkleRoot)
+		fmt.Printf("Bits: %d\n", block.Bits)
+		fmt.Printf("Nonce: %d\n", block.Nonce)
+		if len(block.Signer) > 0 {
+			fmt.Printf("Signer: %x\n", block.Signer)
+		}
+		fmt.Printf("Seal: %s\n", strconv.FormatBool(chain.Consensus().VerifySeal(block) == nil))
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
@@ -72,50 +363,393 @@ func (cli *CommandLine) printChain() {
 			break
 		}
 	}
+	return nil
 }
 
-func (cli *CommandLine) createBlockchain(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+func (cli *CommandLine) proveTransaction(txID string) error {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return err
 	}
-	chain := blockchain.InitBlockchain(address)
-	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	chain.Database.Close()
-	UTXOSet.Reindex()
+	chain, err := blockchain.ContinueBlockchain(cli.options())
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+	block, err := chain.FindTransactionBlock(id)
+	if err != nil {
+		return err
+	}
+	proof, err := block.MerkleProof(id)
+	if err != nil {
+		return err
+	}
+	root := block.HashTransaction()
+	fmt.Printf("Block: %x\n", block.Hash)
+	fmt.Printf("Merkle Root: %x\n", root)
+	fmt.Printf("Index: %d\n", proof.Index)
+	for i, hash := range proof.Path {
+		fmt.Printf("Path %d: %x\n", i, hash)
+	}
+	fmt.Printf("Verified: %s\n", strconv.FormatBool(proof.Verify(root)))
+	return nil
+}
+
+// consensus builds the engine of a new chain. Proof of authority validators
+// must be local wallets, the first one signs the genesis block
+func (cli *CommandLine) consensus(engine, validators string) (blockchain.Consensus, error) {
+	switch engine {
+	case "", blockchain.EngineProofOfWork:
+		return nil, nil
+	case blockchain.EngineProofOfAuthority:
+	default:
+		return nil, fmt.Errorf("%w: %q", blockchain.ErrUnknownConsensus, engine)
+	}
+	wallets, err := cli.unlockWallets()
+	if err != nil {
+		return nil, err
+	}
+	poa := blockchain.NewProofOfAuthority(nil, nil)
+	for _, address := range strings.Split(validators, ",") {
+		w, err := wallets.GetWallets(address)
+		if err != nil {
+			return nil, fmt.Errorf("%w: validator %s", err, address)
+		}
+		poa.Validators = append(poa.Validators, w.PublicKey)
+		if poa.Signer == nil {
+			poa.Signer = &w.PrivateKey
+		}
+	}
+	return poa, nil
+}
+
+// continueBlockchain opens the chain, proof of authority chains sign with the
+// first local wallet that is one of their validators
+func (cli *CommandLine) continueBlockchain() (*blockchain.Blockchain, error) {
+	chain, err := blockchain.ContinueBlockchain(cli.options())
+	if err != nil {
+		return nil, err
+	}
+	poa, ok := chain.Consensus().(*blockchain.ProofOfAuthority)
+	if !ok {
+		return chain, nil
+	}
+	wallets, err := cli.unlockWallets()
+	if err != nil {
+		chain.Close()
+		return nil, err
+	}
+	for _, address := range wallets.GetAllWallets() {
+		w, err := wallets.GetWallets(address)
+		if err == nil && poa.IsValidator(w.PublicKey) {
+			poa.Signer = &w.PrivateKey
+			break
+		}
+	}
+	return chain, nil
+}
+
+func (cli *CommandLine) createBlockchain(address, engine, validators string) error {
+	if err := cli.checkAddress(address); err != nil {
+		return fmt.Errorf("%w: %s", err, address)
+	}
+	if validators == "" {
+		validators = address
+	}
+	consensus, err := cli.consensus(engine, validators)
+	if err != nil {
+		return err
+	}
+	opts := cli.options()
+	opts.Consensus = consensus
+	chain, err := blockchain.InitBlockchain(opts, address)
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
 	fmt.Println("Finished!")
+	return nil
 }
 
-func (cli *CommandLine) send(from, to string, amount int) {
-	if !wallet.ValidateAddress(from) {
-		log.Panic("Sender address is not valid!")
+func (cli *CommandLine) startNode(listen, peers, miner string) error {
+	chain, err := cli.continueBlockchain()
+	if err != nil {
+		return err
 	}
-	if !wallet.ValidateAddress(to) {
-		log.Panic("Receiver address is not valid!")
+	defer chain.Close()
+	node, err := cli.runNode(chain, listen, peers, miner)
+	if err != nil {
+		return err
 	}
-	chain := blockchain.ContinueBlockchain(from)
+	waitInterrupt()
+	return node.Close()
+}
+
+func (cli *CommandLine) runNode(chain *blockchain.Blockchain, listen, peers, miner string) (*network.Node, error) {
+	if miner != "" {
+		if err := cli.checkAddress(miner); err != nil {
+			return nil, fmt.Errorf("%w: miner %s", err, miner)
+		}
+	}
+	node := network.NewNode(listen, chain)
+	node.MinerAddress = miner
+	var seeds []string
+	if peers != "" {
+		seeds = strings.Split(peers, ",")
+	}
+	if err := node.Start(seeds...); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Node listening on %s\n", node.Address)
+	return node, nil
+}
+
+func waitInterrupt() {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+}
+
+// serve runs a node along with a JSON-RPC server sharing its chain, on
+// interrupt the server finishes the requests in flight before the node stops
+func (cli *CommandLine) serve(rpcAddr, listen, peers, miner string) error {
+	chain, err := cli.continueBlockchain()
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+	wallets, err := cli.writableWallets()
+	if err != nil {
+		return err
+	}
+	node, err := cli.runNode(chain, listen, peers, miner)
+	if err != nil {
+		return err
+	}
+	server := rpc.NewServer(rpcAddr, node, wallets)
+	if err := server.Start(); err != nil {
+		node.Close()
+		return err
+	}
+	fmt.Printf("JSON-RPC server listening on %s\n", server.Address)
+	waitInterrupt()
+	err = server.Close()
+	if nodeErr := node.Close(); err == nil {
+		err = nodeErr
+	}
+	return err
+}
+
+func (cli *CommandLine) send(from, to string, amount, fee int, nodeAddr string) error {
+	if err := cli.checkAddress(from); err != nil {
+		return fmt.Errorf("%w: sender %s", err, from)
+	}
+	if err := cli.checkAddress(to); err != nil {
+		return fmt.Errorf("%w: receiver %s", err, to)
+	}
+	wallets, err := cli.unlockWallets()
+	if err != nil {
+		return err
+	}
+	w, err := wallets.GetWallets(from)
+	if err != nil {
+		return err
+	}
+	if nodeAddr != "" {
+		// the node owns the chain, the local database may be locked by it or
+		// behind it
+		unspent, err := network.RequestUnspent(nodeAddr, from)
+		if err != nil {
+			return err
+		}
+		tx, err := blockchain.SpendOutputs(&w, to, amount, fee, unspent, cli.params)
+		if err != nil {
+			return err
+		}
+		if err := network.SendTransaction(nodeAddr, tx); err != nil {
+			return err
+		}
+		fmt.Printf("Transaction %x sent to %s\n", tx.ID, nodeAddr)
+		return nil
+	}
+	chain, err := cli.continueBlockchain()
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	defer chain.Database.Close()
-	tx := blockchain.NewTransaction(from, to, amount, &UTXOSet)
-	block := chain.AddBlock([]*blockchain.Transaction{tx})
-	UTXOSet.Update(block)
+	tx, err := blockchain.NewTransaction(&w, to, amount, fee, &UTXOSet)
+	if err != nil {
+		return err
+	}
+	subsidy, err := chain.NextSubsidy()
+	if err != nil {
+		return err
+	}
+	cbTx, err := blockchain.CoinbaseTx(from, "", subsidy+fee, chain.Params())
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var progress progressLine
+	_, err = chain.MineBlock(ctx, []*blockchain.Transaction{cbTx, tx}, progress.options())
+	progress.end()
+	if err != nil {
+		return err
+	}
 	fmt.Println("Success!")
+	return nil
+}
+
+// mine appends blocks paying address. With nodeAddr the node running there
+// mines them from its pending transactions, otherwise the CLI mines blocks
+// holding the coinbase alone since it has no transactions waiting
+func (cli *CommandLine) mine(address string, blocks int, nodeAddr string) error {
+	if err := cli.checkAddress(address); err != nil {
+		return fmt.Errorf("%w: %s", err, address)
+	}
+	if nodeAddr != "" {
+		mined, err := network.RequestMine(nodeAddr, address, blocks)
+		for _, block := range mined {
+			fmt.Printf("Mined block %d %x with %d transactions, reward %d\n", block.Height, block.Hash, block.Transactions, block.Reward)
+		}
+		return err
+	}
+	chain, err := cli.continueBlockchain()
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+	var progress progressLine
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for i := 0; i < blocks; i++ {
+		subsidy, err := chain.NextSubsidy()
+		if err != nil {
+			return err
+		}
+		cbTx, err := blockchain.CoinbaseTx(address, "", subsidy, chain.Params())
+		if err != nil {
+			return err
+		}
+		block, err := chain.MineBlock(ctx, []*blockchain.Transaction{cbTx}, progress.options())
+		progress.end()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Mined block %d %x, reward %d\n", block.Height, block.Hash, subsidy)
+	}
+	return nil
 }
 
-func (cli *CommandLine) getBalance(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not valid!")
+// progressLine reports the hashrate on a single terminal line, engines that
+// do not mine never print it
+type progressLine struct {
+	printed bool
+}
+
+func (p *progressLine) options() blockchain.MineOptions {
+	return blockchain.MineOptions{
+		Progress: func(stats blockchain.MineStats) {
+			p.printed = true
+			fmt.Printf("\rMining: %d hashes in %s, %.0f H/s", stats.Hashes, stats.Elapsed.Round(time.Millisecond), stats.Hashrate())
+		},
+	}
+}
+
+func (p *progressLine) end() {
+	if p.printed {
+		fmt.Println()
+		p.printed = false
+	}
+}
+
+func (cli *CommandLine) getBalance(address string) error {
+	if err := cli.checkAddress(address); err != nil {
+		return fmt.Errorf("%w: %s", err, address)
+	}
+	pubKeyHash, err := wallet.AddressPubKeyHash(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, address)
 	}
-	chain := blockchain.ContinueBlockchain(address)
+	chain, err := blockchain.ContinueBlockchain(cli.options())
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
 	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
-	defer chain.Database.Close()
 	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	UTXOs, err := UTXOSet.FindUnspentTransactions(pubKeyHash)
+	if err != nil {
+		return err
+	}
 	for _, out := range UTXOs {
 		balance += out.Value
 	}
 	fmt.Printf("Balance of %s: %d\n", address, balance)
+	return nil
+}
+
+// history prints a page of the address index entries of an address
+func (cli *CommandLine) history(address string, skip, count int) error {
+	if err := cli.checkAddress(address); err != nil {
+		return fmt.Errorf("%w: %s", err, address)
+	}
+	pubKeyHash, err := wallet.AddressPubKeyHash(address)
+	if err != nil {
+		return err
+	}
+	chain, err := blockchain.ContinueBlockchain(cli.options())
+	if err != nil {
+		return err
+	}
+	defer chain.Close()
+	history, total, err := chain.AddressHistory(pubKeyHash, skip, count)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("History of %s: %d transactions\n", address, total)
+	for _, atx := range history {
+		fmt.Printf("%x height %d: received %d, spent %d, net %+d\n", atx.TxID, atx.Height, atx.Received, atx.Spent, atx.Net())
+	}
+	if len(history) > 0 {
+		fmt.Printf("Showing %d to %d\n", skip+1, skip+len(history))
+	}
+	return nil
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, wallet.ErrInvalidAddress), errors.Is(err, wallet.ErrWrongNetwork), errors.Is(err, wallet.ErrWalletNotFound),
+		errors.Is(err, wallet.ErrInvalidMnemonic), errors.Is(err, wallet.ErrSeedExists),
+		errors.Is(err, wallet.ErrInvalidPrivateKey), errors.Is(err, blockchain.ErrUnknownNetwork):
+		return 2
+	case errors.Is(err, wallet.ErrWrongPassphrase), errors.Is(err, wallet.ErrWalletLocked),
+		errors.Is(err, wallet.ErrNoPassphrase):
+		return 8
+	case errors.Is(err, blockchain.ErrNoChain):
+		return 3
+	case errors.Is(err, blockchain.ErrChainExists):
+		return 4
+	case errors.Is(err, blockchain.ErrInsufficientFunds):
+		return 5
+	case errors.Is(err, blockchain.ErrTxNotFound), errors.Is(err, blockchain.ErrInvalidPrevTx):
+		return 6
+	case errors.Is(err, blockchain.ErrInvalidSignature), errors.Is(err, blockchain.ErrInvalidValue), errors.Is(err, blockchain.ErrValueOutOfRange),
+		errors.Is(err, blockchain.ErrDoubleSpend), errors.Is(err, blockchain.ErrOutputSpent):
+		return 7
+	default:
+		return 1
+	}
+}
+
+func (cli *CommandLine) exit(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	os.Exit(exitCode(err))
 }
 
 func (cli *CommandLine) Run() {
@@ -125,14 +759,62 @@ func (cli *CommandLine) Run() {
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("print", flag.ExitOnError)
 	walletCmd := flag.NewFlagSet("wallet", flag.ExitOnError)
+	changePassCmd := flag.NewFlagSet("wallet changepass", flag.ExitOnError)
+	mnemonicCmd := flag.NewFlagSet("wallet mnemonic", flag.ExitOnError)
+	restoreCmd := flag.NewFlagSet("wallet restore", flag.ExitOnError)
+	exportCmd := flag.NewFlagSet("wallet export", flag.ExitOnError)
+	importCmd := flag.NewFlagSet("wallet import", flag.ExitOnError)
+	walletSubCmds := map[string]*flag.FlagSet{
+		"changepass": changePassCmd,
+		"mnemonic":   mnemonicCmd,
+		"restore":    restoreCmd,
+		"export":     exportCmd,
+		"import":     importCmd,
+	}
 	walletsCmd := flag.NewFlagSet("wallets", flag.ExitOnError)
 	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	proofCmd := flag.NewFlagSet("proof", flag.ExitOnError)
+	for _, cmd := range []*flag.FlagSet{getBalanceCmd, createBlockchainCmd, sendCmd, printChainCmd, walletCmd, changePassCmd, mnemonicCmd, restoreCmd, exportCmd, importCmd, walletsCmd, reindexCmd, historyCmd, supplyCmd, mineCmd, startNodeCmd, serveCmd, proofCmd} {
+		cmd.StringVar(&cli.dataDir, "datadir", blockchain.DefaultDataDir, "Directory holding the chain and wallets")
+		cmd.StringVar(&cli.network, "network", "", "Network to use: main, test or regtest")
+		cmd.StringVar(&cli.passphrase, "passphrase", "", "Wallet file passphrase")
+	}
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", blockchain.EngineProofOfWork, "Consensus engine: pow or poa")
+	createBlockchainValidators := createBlockchainCmd.String("validators", "", "Comma separated wallet addresses allowed to sign poa blocks, the genesis address by default")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee left to the miner")
+	sendNode := sendCmd.String("node", "", "Hand the transaction to the node at this address instead of mining it")
+	changePassNew := changePassCmd.String("new-passphrase", "", "The new passphrase, asked for when empty")
+	exportAddress := exportCmd.String("address", "", "The address whose key to export")
+	importKey := importCmd.String("key", "", "The exported private key")
+	restoreMnemonic := restoreCmd.String("mnemonic", "", "The recovery phrase")
+	restoreCount := restoreCmd.Int("count", 20, "Number of addresses to derive")
+	reindexTxIndex := reindexCmd.Bool("txindex", false, "Build the transaction index, which is kept up to date from then on")
+	reindexAddrIndex := reindexCmd.Bool("addrindex", false, "Build the address index, which is kept up to date from then on")
+	historyAddress := historyCmd.String("address", "", "The address to list the transactions of")
+	historySkip := historyCmd.Int("skip", 0, "Number of newer transactions to skip")
+	historyCount := historyCmd.Int("count", 20, "Number of transactions to list")
+	mineAddress := mineCmd.String("address", "", "The address to pay the block rewards to")
+	mineBlocks := mineCmd.Int("blocks", 1, "Number of blocks to mine")
+	mineNode := mineCmd.String("node", "", "Have the node on this host at this address mine its pending transactions")
+	proofTx := proofCmd.String("tx", "", "ID of the transaction to prove")
+	startNodeListen := startNodeCmd.String("listen", "localhost:3000", "Address the node listens on")
+	startNodePeers := startNodeCmd.String("peers", "", "Comma separated addresses of the peers to connect to")
+	startNodeMiner := startNodeCmd.String("miner", "", "Mine pending transactions and pay the rewards to this address")
+	serveRPC := serveCmd.String("rpc", "localhost:8332", "Address the JSON-RPC server listens on")
+	serveListen := serveCmd.String("listen", "localhost:3000", "Address the node listens on")
+	servePeers := serveCmd.String("peers", "", "Comma separated addresses of the peers to connect to")
+	serveMiner := serveCmd.String("miner", "", "Mine pending transactions and pay the rewards to this address")
 	switch os.Args[1] {
 	case "balance":
 		err := getBalanceCmd.Parse(os.Args[2:])
@@ -155,7 +837,11 @@ func (cli *CommandLine) Run() {
 			log.Panic(err)
 		}
 	case "wallet":
-		err := walletCmd.Parse(os.Args[2:])
+		cmd, args := walletCmd, os.Args[2:]
+		if len(args) > 0 && walletSubCmds[args[0]] != nil {
+			cmd, args = walletSubCmds[args[0]], args[1:]
+		}
+		err := cmd.Parse(args)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -169,41 +855,131 @@ func (cli *CommandLine) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mine":
+		err := mineCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "supply":
+		err := supplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "proof":
+		err := proofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "serve":
+		err := serveCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		runtime.Goexit()
 	}
+	params, err := cli.options().NetworkParams()
+	cli.exit(err)
+	cli.params = params
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.getBalance(*getBalanceAddress)
+		cli.exit(cli.getBalance(*getBalanceAddress))
 	}
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.createBlockchain(*createBlockchainAddress)
+		cli.exit(cli.createBlockchain(*createBlockchainAddress, *createBlockchainConsensus, *createBlockchainValidators))
 	}
 	if printChainCmd.Parsed() {
-		cli.printChain()
+		cli.exit(cli.printChain())
 	}
 	if walletCmd.Parsed() {
-		cli.createWallet()
+		cli.exit(cli.createWallet())
+	}
+	if changePassCmd.Parsed() {
+		cli.exit(cli.changePassphrase(*changePassNew))
+	}
+	if exportCmd.Parsed() {
+		if *exportAddress == "" {
+			exportCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exit(cli.exportKey(*exportAddress))
+	}
+	if importCmd.Parsed() {
+		if *importKey == "" {
+			importCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exit(cli.importKey(*importKey))
+	}
+	if mnemonicCmd.Parsed() {
+		cli.exit(cli.showMnemonic())
+	}
+	if restoreCmd.Parsed() {
+		if *restoreMnemonic == "" || *restoreCount <= 0 {
+			restoreCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exit(cli.restoreWallets(*restoreMnemonic, *restoreCount))
 	}
 	if walletsCmd.Parsed() {
-		cli.listAddresses()
+		cli.exit(cli.listAddresses())
+	}
+	if proofCmd.Parsed() {
+		if *proofTx == "" {
+			proofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exit(cli.proveTransaction(*proofTx))
+	}
+	if startNodeCmd.Parsed() {
+		cli.exit(cli.startNode(*startNodeListen, *startNodePeers, *startNodeMiner))
+	}
+	if serveCmd.Parsed() {
+		cli.exit(cli.serve(*serveRPC, *serveListen, *servePeers, *serveMiner))
+	}
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historySkip < 0 || *historyCount <= 0 {
+			historyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exit(cli.history(*historyAddress, *historySkip, *historyCount))
 	}
 	if reindexCmd.Parsed() {
-		cli.reindex()
+		cli.exit(cli.reindex(*reindexTxIndex, *reindexAddrIndex))
+	}
+	if mineCmd.Parsed() {
+		if *mineAddress == "" || *mineBlocks <= 0 {
+			mineCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exit(cli.mine(*mineAddress, *mineBlocks, *mineNode))
+	}
+	if supplyCmd.Parsed() {
+		cli.exit(cli.supply())
 	}
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+		cli.exit(cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendNode))
 	}
 }
@@ -0,0 +1,148 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/nd-sin/blockchain/blockchain"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	protocol      = "tcp"
+	version       = 1
+	commandLength = 12
+	maxMessage    = 32 << 20
+)
+
+const (
+	cmdVersion    = "version"
+	cmdAddr       = "addr"
+	cmdGetBlocks  = "getblocks"
+	cmdInv        = "inv"
+	cmdGetData    = "getdata"
+	cmdBlock      = "block"
+	cmdTx         = "tx"
+	cmdMine       = "mine"
+	cmdMined      = "mined"
+	cmdGetUnspent = "getunspent"
+	cmdUnspent    = "unspent"
+)
+
+const (
+	invBlock = "block"
+	invTx    = "tx"
+)
+
+var (
+	ErrBadMessage = errors.New("malformed network message")
+	ErrNotLocal   = errors.New("mine requests are only accepted from this host")
+)
+
+type Version struct {
+	Version    int
+	BestHeight int
+	AddrFrom   string
+}
+
+type Addr struct {
+	AddrList []string
+}
+
+type GetBlocks struct {
+	AddrFrom string
+}
+
+type Inv struct {
+	AddrFrom string
+	Type     string
+	Items    [][]byte
+}
+
+type GetData struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
+type BlockMsg struct {
+	AddrFrom string
+	Block    []byte
+}
+
+type TxMsg struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+// Mine asks a node to mine blocks from its mempool paying Address, the node
+// answers on the same connection with Mined
+type Mine struct {
+	Address string
+	Blocks  int
+}
+
+type Mined struct {
+	Blocks []MinedBlock
+	// Error is why the node stopped before mining every block
+	Error string
+}
+
+type MinedBlock struct {
+	Hash         []byte
+	Height       int
+	Transactions int
+	Reward       int
+}
+
+// GetUnspent asks a node for the unspent outputs of Address that its pending
+// transactions leave, the node answers on the same connection with Unspent
+type GetUnspent struct {
+	Address string
+}
+
+type Unspent struct {
+	Outputs []blockchain.UnspentOutput
+	Error   string
+}
+
+func CmdToBytes(cmd string) []byte {
+	var bytes [commandLength]byte
+	copy(bytes[:], cmd)
+	return bytes[:]
+}
+
+func BytesToCmd(bytes []byte) string {
+	var cmd []byte
+	for _, b := range bytes {
+		if b != 0x0 {
+			cmd = append(cmd, b)
+		}
+	}
+	return string(cmd)
+}
+
+func encodeMessage(cmd string, payload interface{}) ([]byte, error) {
+	var buff bytes.Buffer
+	buff.Write(CmdToBytes(cmd))
+	if err := gob.NewEncoder(&buff).Encode(payload); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func readMessage(r io.Reader) (string, []byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxMessage))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) < commandLength {
+		return "", nil, ErrBadMessage
+	}
+	return BytesToCmd(data[:commandLength]), data[commandLength:], nil
+}
+
+func decodePayload(data []byte, payload interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(payload)
+}
@@ -0,0 +1,726 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nd-sin/blockchain/blockchain"
+	"github.com/nd-sin/blockchain/wallet"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout         = 5 * time.Second
+	DefaultMineInterval = 10 * time.Second
+	// ioTimeout bounds reading or writing a whole message, a peer that stalls
+	// cannot hold a connection open
+	ioTimeout = 30 * time.Second
+)
+
+type Node struct {
+	Address string
+	// MinerAddress enables mining, pending transactions are mined every
+	// MineInterval with the coinbase paying this address
+	MinerAddress string
+	MineInterval time.Duration
+	// MaxBlockTxs caps the transactions pulled from the mempool per block
+	MaxBlockTxs int
+	// MineOptions tunes the proof of work search of mined blocks
+	MineOptions blockchain.MineOptions
+	Mempool     *blockchain.Mempool
+
+	chain    *blockchain.Blockchain
+	utxo     blockchain.UTXOSet
+	listener net.Listener
+	wg       sync.WaitGroup
+	quit     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu              sync.Mutex
+	peers           map[string]bool
+	conns           map[net.Conn]bool
+	blocksInTransit [][]byte
+	// cancelMining stops the block being mined, a new tip makes it stale
+	cancelMining context.CancelFunc
+}
+
+func NewNode(address string, chain *blockchain.Blockchain) *Node {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Node{
+		Address:      address,
+		MineInterval: DefaultMineInterval,
+		Mempool:      blockchain.NewMempool(chain),
+		chain:        chain,
+		utxo:         blockchain.UTXOSet{Blockchain: chain},
+		quit:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+		peers:        make(map[string]bool),
+		conns:        make(map[net.Conn]bool),
+	}
+}
+
+// Start listens on the node address and announces the node to the seeds,
+// an address with port 0 is replaced by the port actually bound
+func (n *Node) Start(seeds ...string) error {
+	ln, err := net.Listen(protocol, n.Address)
+	if err != nil {
+		return err
+	}
+	n.listener = ln
+	n.Address = ln.Addr().String()
+	n.wg.Add(1)
+	go n.serve()
+	if n.MinerAddress != "" {
+		n.wg.Add(1)
+		go n.mineLoop()
+	}
+	for _, seed := range seeds {
+		n.AddPeer(seed)
+		if err := n.sendVersion(seed); err != nil {
+			log.Printf("seed %s unreachable: %s", seed, err)
+		}
+	}
+	return nil
+}
+
+// Close stops the node, connections still being read are cut
+func (n *Node) Close() error {
+	close(n.quit)
+	n.cancel()
+	err := n.listener.Close()
+	n.mu.Lock()
+	for conn := range n.conns {
+		conn.Close()
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+	return err
+}
+
+func (n *Node) serve() {
+	defer n.wg.Done()
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println(err)
+			continue
+		}
+		if !n.trackConn(conn) {
+			conn.Close()
+			return
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			defer n.untrackConn(conn)
+			n.handleConnection(conn)
+		}()
+	}
+}
+
+// trackConn records an accepted connection for Close to cut, it fails once
+// the node is closing
+func (n *Node) trackConn(conn net.Conn) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.quit:
+		return false
+	default:
+	}
+	n.conns[conn] = true
+	return true
+}
+
+func (n *Node) untrackConn(conn net.Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.conns, conn)
+}
+
+func (n *Node) AddPeer(addr string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if addr == "" || addr == n.Address || n.peers[addr] {
+		return false
+	}
+	n.peers[addr] = true
+	return true
+}
+
+func (n *Node) RemovePeer(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.peers, addr)
+}
+
+func (n *Node) Peers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.peerList("")
+}
+
+func (n *Node) peerList(except string) []string {
+	var peers []string
+	for peer := range n.peers {
+		if peer != except {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// View runs fn with the chain of the node, which does not change the chain
+// until fn returns
+func (n *Node) View(fn func(chain *blockchain.Blockchain) error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return fn(n.chain)
+}
+
+// AddBlock mines the transactions into a new block and gossips it. The proof
+// of work runs without holding the node lock and is abandoned with
+// ErrStaleBlock when another block takes the tip first
+func (n *Node) AddBlock(txs []*blockchain.Transaction) (*blockchain.Block, error) {
+	return n.mineBlock(func() ([]*blockchain.Transaction, error) {
+		return txs, nil
+	})
+}
+
+// mineBlock mines the transactions assemble returns, assemble runs under the
+// node lock so they are checked against the tip they are mined on
+func (n *Node) mineBlock(assemble func() ([]*blockchain.Transaction, error)) (*blockchain.Block, error) {
+	n.mu.Lock()
+	txs, err := assemble()
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	block, err := n.chain.PrepareBlock(txs)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(n.ctx)
+	defer cancel()
+	n.cancelMining = cancel
+	n.mu.Unlock()
+
+	err = n.chain.SealBlock(ctx, block, n.MineOptions)
+
+	n.mu.Lock()
+	n.cancelMining = nil
+	if err == context.Canceled && n.ctx.Err() == nil {
+		err = blockchain.ErrStaleBlock
+	}
+	if err == nil {
+		block, err = n.connectMined(block)
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	n.broadcastInv(invBlock, block.Hash, "")
+	return block, nil
+}
+
+// SubmitTransaction verifies a transaction, adds it to the mempool and
+// gossips it
+func (n *Node) SubmitTransaction(tx *blockchain.Transaction) error {
+	return n.acceptTransaction(tx, "")
+}
+
+// MinePending mines a block template from the mempool, it returns nil when
+// there is nothing to mine
+func (n *Node) MinePending() (*blockchain.Block, error) {
+	if n.Mempool.Count() == 0 {
+		return nil, nil
+	}
+	return n.MineTo(n.MinerAddress)
+}
+
+// MineTo mines a block template from the mempool paying its rewards to
+// address, the block holds the coinbase alone when nothing is pending
+func (n *Node) MineTo(address string) (*blockchain.Block, error) {
+	return n.mineBlock(func() ([]*blockchain.Transaction, error) {
+		return n.Mempool.BlockTemplate(address, n.MaxBlockTxs)
+	})
+}
+
+func (n *Node) mineLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.MineInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-ticker.C:
+			if _, err := n.Mempool.EvictStale(); err != nil {
+				log.Println(err)
+			}
+			block, err := n.MinePending()
+			if err != nil {
+				log.Println(err)
+			} else if block != nil {
+				log.Printf("mined block %x with %d transactions", block.Hash, len(block.Transactions))
+			}
+		}
+	}
+}
+
+func (n *Node) connectMined(block *blockchain.Block) (*blockchain.Block, error) {
+	change, err := n.chain.AcceptBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(change.Connected) == 0 {
+		return nil, blockchain.ErrStaleBlock
+	}
+	n.updateMempool(change)
+	return block, nil
+}
+
+func (n *Node) acceptTransaction(tx *blockchain.Transaction, from string) error {
+	n.mu.Lock()
+	err := n.Mempool.Add(tx)
+	n.mu.Unlock()
+	if err == blockchain.ErrTxInMempool {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n.broadcastInv(invTx, tx.ID, from)
+	return nil
+}
+
+func (n *Node) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		log.Println(err)
+		return
+	}
+	cmd, payload, err := readMessage(conn)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	switch cmd {
+	case cmdVersion:
+		err = n.handleVersion(payload)
+	case cmdAddr:
+		err = n.handleAddr(payload)
+	case cmdGetBlocks:
+		err = n.handleGetBlocks(payload)
+	case cmdInv:
+		err = n.handleInv(payload)
+	case cmdGetData:
+		err = n.handleGetData(payload)
+	case cmdBlock:
+		err = n.handleBlock(payload)
+	case cmdTx:
+		err = n.handleTx(payload)
+	case cmdMine:
+		err = n.handleMine(conn, payload)
+	case cmdGetUnspent:
+		err = n.handleGetUnspent(conn, payload)
+	default:
+		err = ErrBadMessage
+	}
+	if err != nil {
+		log.Printf("%s from %s: %s", cmd, conn.RemoteAddr(), err)
+	}
+}
+
+func (n *Node) handleVersion(payload []byte) error {
+	var msg Version
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	height, err := n.chain.Height()
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	isNew := n.AddPeer(msg.AddrFrom)
+	if height < msg.BestHeight {
+		err = n.send(msg.AddrFrom, cmdGetBlocks, GetBlocks{n.Address})
+	} else if height > msg.BestHeight {
+		err = n.sendVersion(msg.AddrFrom)
+	}
+	if err != nil || !isNew {
+		return err
+	}
+	return n.send(msg.AddrFrom, cmdAddr, Addr{append(n.Peers(), n.Address)})
+}
+
+func (n *Node) handleAddr(payload []byte) error {
+	var msg Addr
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	for _, addr := range msg.AddrList {
+		if n.AddPeer(addr) {
+			if err := n.sendVersion(addr); err != nil {
+				log.Printf("peer %s unreachable: %s", addr, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Node) handleGetBlocks(payload []byte) error {
+	var msg GetBlocks
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	height, err := n.chain.Height()
+	var hashes [][]byte
+	if err == nil {
+		hashes, err = n.chain.GetBlockHashes(0, height)
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// inventories list the tip first
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return n.send(msg.AddrFrom, cmdInv, Inv{n.Address, invBlock, hashes})
+}
+
+func (n *Node) handleInv(payload []byte) error {
+	var msg Inv
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case invBlock:
+		n.mu.Lock()
+		var missing [][]byte
+		// inventories list the tip first, request the oldest block first so
+		// every block arrives after its parent
+		for i := len(msg.Items) - 1; i >= 0; i-- {
+			exists, err := n.chain.HasBlock(msg.Items[i])
+			if err != nil {
+				n.mu.Unlock()
+				return err
+			}
+			if !exists {
+				missing = append(missing, msg.Items[i])
+			}
+		}
+		if len(missing) == 0 {
+			n.mu.Unlock()
+			return nil
+		}
+		n.blocksInTransit = missing[1:]
+		n.mu.Unlock()
+		return n.send(msg.AddrFrom, cmdGetData, GetData{n.Address, invBlock, missing[0]})
+	case invTx:
+		for _, id := range msg.Items {
+			if n.Mempool.Has(id) {
+				continue
+			}
+			if err := n.send(msg.AddrFrom, cmdGetData, GetData{n.Address, invTx, id}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Node) handleGetData(payload []byte) error {
+	var msg GetData
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case invBlock:
+		n.mu.Lock()
+		block, err := n.chain.GetBlock(msg.ID)
+		n.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return n.send(msg.AddrFrom, cmdBlock, BlockMsg{n.Address, block.Serialize()})
+	case invTx:
+		tx, ok := n.Mempool.Get(msg.ID)
+		if !ok {
+			return blockchain.ErrTxNotFound
+		}
+		return n.send(msg.AddrFrom, cmdTx, TxMsg{n.Address, tx.Serialize()})
+	}
+	return nil
+}
+
+func (n *Node) handleBlock(payload []byte) error {
+	var msg BlockMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	block, err := blockchain.Deserialize(msg.Block)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	orphan := false
+	change, err := n.chain.AcceptBlock(block)
+	if err == blockchain.ErrOrphanBlock {
+		orphan, err = true, nil
+	} else if err == nil {
+		n.updateMempool(change)
+		if len(change.Connected) > 0 && n.cancelMining != nil {
+			n.cancelMining()
+		}
+	}
+	var next []byte
+	for len(n.blocksInTransit) > 0 && next == nil {
+		candidate := n.blocksInTransit[0]
+		n.blocksInTransit = n.blocksInTransit[1:]
+		if !bytes.Equal(candidate, block.Hash) {
+			next = candidate
+		}
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		return n.send(msg.AddrFrom, cmdGetData, GetData{n.Address, invBlock, next})
+	}
+	if orphan {
+		return n.send(msg.AddrFrom, cmdGetBlocks, GetBlocks{n.Address})
+	}
+	if len(change.Connected) > 0 {
+		n.broadcastInv(invBlock, chainTip(change).Hash, msg.AddrFrom)
+	}
+	return nil
+}
+
+// updateMempool drops the transactions mined by a chain change and gives the
+// ones from disconnected blocks another chance
+func (n *Node) updateMempool(change *blockchain.ChainChange) {
+	for _, block := range change.Connected {
+		n.Mempool.RemoveBlock(block)
+	}
+	for _, block := range change.Disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				_ = n.Mempool.Add(tx)
+			}
+		}
+	}
+	if len(change.Disconnected) > 0 {
+		if _, err := n.Mempool.EvictStale(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func chainTip(change *blockchain.ChainChange) *blockchain.Block {
+	return change.Connected[len(change.Connected)-1]
+}
+
+func (n *Node) handleTx(payload []byte) error {
+	var msg TxMsg
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	tx, err := blockchain.DeserializeTransaction(msg.Transaction)
+	if err != nil {
+		return err
+	}
+	return n.acceptTransaction(tx, msg.AddrFrom)
+}
+
+// handleMine serves RequestMine, only to clients on this host since mining
+// takes the node CPU. Blocks made stale by a peer are mined again
+func (n *Node) handleMine(conn net.Conn, payload []byte) error {
+	var msg Mine
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	var reply Mined
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		reply.Error = ErrNotLocal.Error()
+	}
+	for len(reply.Blocks) < msg.Blocks && reply.Error == "" {
+		block, err := n.MineTo(msg.Address)
+		if err == blockchain.ErrStaleBlock {
+			continue
+		}
+		if err != nil {
+			reply.Error = err.Error()
+			break
+		}
+		reward := block.Transactions[0].Outputs[0].Value
+		reply.Blocks = append(reply.Blocks, MinedBlock{block.Hash, block.Height, len(block.Transactions), reward})
+	}
+	return writeReply(conn, cmdMined, reply)
+}
+
+// handleGetUnspent serves RequestUnspent, outputs spent by the mempool are
+// left out so a client can send again before the node mines
+func (n *Node) handleGetUnspent(conn net.Conn, payload []byte) error {
+	var msg GetUnspent
+	if err := decodePayload(payload, &msg); err != nil {
+		return err
+	}
+	var reply Unspent
+	err := n.View(func(chain *blockchain.Blockchain) error {
+		if err := wallet.CheckAddress(msg.Address, chain.Params().AddressVersion); err != nil {
+			return fmt.Errorf("%w: %s", err, msg.Address)
+		}
+		pubKeyHash, err := wallet.AddressPubKeyHash(msg.Address)
+		if err != nil {
+			return err
+		}
+		reply.Outputs, err = n.utxo.FindUnspentOutputs(pubKeyHash)
+		return err
+	})
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	reply.Outputs = n.Mempool.Unspent(reply.Outputs)
+	return writeReply(conn, cmdUnspent, reply)
+}
+
+// writeReply answers a request on the connection it came in on
+func writeReply(conn net.Conn, cmd string, payload interface{}) error {
+	data, err := encodeMessage(cmd, payload)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+func (n *Node) sendVersion(addr string) error {
+	n.mu.Lock()
+	height, err := n.chain.Height()
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return n.send(addr, cmdVersion, Version{version, height, n.Address})
+}
+
+func (n *Node) broadcastInv(kind string, id []byte, except string) {
+	n.mu.Lock()
+	peers := n.peerList(except)
+	n.mu.Unlock()
+	for _, peer := range peers {
+		if err := n.send(peer, cmdInv, Inv{n.Address, kind, [][]byte{id}}); err != nil {
+			log.Printf("peer %s unreachable: %s", peer, err)
+		}
+	}
+}
+
+// send delivers one message per connection and forgets peers that cannot be
+// reached
+func (n *Node) send(addr, cmd string, payload interface{}) error {
+	err := sendMessage(addr, cmd, payload)
+	if err != nil {
+		n.RemovePeer(addr)
+	}
+	return err
+}
+
+func sendMessage(addr, cmd string, payload interface{}) error {
+	data, err := encodeMessage(cmd, payload)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// SendTransaction hands a transaction to a running node without joining the
+// network
+func SendTransaction(addr string, tx *blockchain.Transaction) error {
+	return sendMessage(addr, cmdTx, TxMsg{"", tx.Serialize()})
+}
+
+// RequestMine has a node running on this host mine blocks from its mempool
+// paying address, it waits for the node to mine them and returns what it
+// mined
+func RequestMine(addr, address string, blocks int) ([]MinedBlock, error) {
+	var msg Mined
+	if err := request(addr, cmdMine, Mine{address, blocks}, cmdMined, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Error != "" {
+		return msg.Blocks, errors.New(msg.Error)
+	}
+	return msg.Blocks, nil
+}
+
+// RequestUnspent fetches the outputs address can spend from a node, so a
+// transaction can be built without a copy of the chain
+func RequestUnspent(addr, address string) ([]blockchain.UnspentOutput, error) {
+	var msg Unspent
+	if err := request(addr, cmdGetUnspent, GetUnspent{address}, cmdUnspent, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Error != "" {
+		return nil, errors.New(msg.Error)
+	}
+	return msg.Outputs, nil
+}
+
+// request sends a message and decodes the reply the node writes back on the
+// same connection
+func request(addr, cmd string, payload interface{}, replyCmd string, reply interface{}) error {
+	data, err := encodeMessage(cmd, payload)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetWriteDeadline(time.Now().Add(ioTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	// the node reads the request up to the end of the stream
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return err
+	}
+	cmd, data, err = readMessage(conn)
+	if err != nil {
+		return err
+	}
+	if cmd != replyCmd {
+		return ErrBadMessage
+	}
+	return decodePayload(data, reply)
+}
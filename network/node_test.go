@@ -0,0 +1,247 @@
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nd-sin/blockchain/blockchain"
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+// testChains creates a regtest chain paying its genesis to w and copies it,
+// so both chains share their genesis block
+func testChains(t *testing.T, w *wallet.Wallet) (*blockchain.Blockchain, *blockchain.Blockchain) {
+	t.Helper()
+	chain, err := blockchain.NewTestChain(blockchain.Options{DataDir: t.TempDir()}, string(w.NetworkAddress(blockchain.RegTestParams.AddressVersion)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	optsA := chain.Options
+	optsB := optsA
+	optsB.DataDir = t.TempDir()
+	if err := chain.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyDir(optsA.BlocksDir(), optsB.BlocksDir()); err != nil {
+		t.Fatal(err)
+	}
+	var chains []*blockchain.Blockchain
+	for _, opts := range []blockchain.Options{optsA, optsB} {
+		chain, err := blockchain.ContinueBlockchain(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { chain.Close() })
+		chains = append(chains, chain)
+	}
+	return chains[0], chains[1]
+}
+
+func copyDir(from, to string) error {
+	if err := os.MkdirAll(to, 0700); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(from, file.Name()))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(to, file.Name()), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startNode(t *testing.T, chain *blockchain.Blockchain, seeds ...string) *Node {
+	t.Helper()
+	node := NewNode("127.0.0.1:0", chain)
+	if err := node.Start(seeds...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func height(t *testing.T, node *Node) int {
+	var height int
+	err := node.View(func(chain *blockchain.Blockchain) error {
+		var err error
+		height, err = chain.Height()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return height
+}
+
+func TestTwoNodes(t *testing.T) {
+	w := wallet.MakeWallet()
+	address := string(w.NetworkAddress(blockchain.RegTestParams.AddressVersion))
+	chainA, chainB := testChains(t, w)
+	a := startNode(t, chainA)
+	b := startNode(t, chainB, a.Address)
+	waitFor(t, "the nodes to know each other", func() bool {
+		return len(a.Peers()) == 1 && len(b.Peers()) == 1
+	})
+
+	coinbase, err := blockchain.CoinbaseTx(address, "", blockchain.RegTestParams.Subsidy(1), chainA.Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := a.AddBlock([]*blockchain.Transaction{coinbase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the block to reach the second node", func() bool {
+		return height(t, b) == 1
+	})
+	err = b.View(func(chain *blockchain.Blockchain) error {
+		hash, err := chain.GetBlockHash(1)
+		if err == nil && string(hash) != string(block.Hash) {
+			t.Fatalf("second node has block %x at height 1, want %x", hash, block.Hash)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tx *blockchain.Transaction
+	err = a.View(func(chain *blockchain.Blockchain) error {
+		var err error
+		tx, err = blockchain.NewTransaction(w, address, 30, 1, &blockchain.UTXOSet{Blockchain: chain})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the transaction to reach the second node", func() bool {
+		return b.Mempool.Has(tx.ID)
+	})
+
+	b.MinerAddress = address
+	block, err = b.MinePending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block == nil || len(block.Transactions) != 2 {
+		t.Fatalf("second node mined %v, want the coinbase and the transaction", block)
+	}
+	waitFor(t, "the mined transaction to leave the first node mempool", func() bool {
+		return height(t, a) == 2 && !a.Mempool.Has(tx.ID)
+	})
+}
+
+func TestCloseCutsIdleConnections(t *testing.T) {
+	w := wallet.MakeWallet()
+	chain, _ := testChains(t, w)
+	node := NewNode("127.0.0.1:0", chain)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial(protocol, node.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// let the node accept the connection before closing
+	time.Sleep(100 * time.Millisecond)
+	closed := make(chan error)
+	go func() { closed <- node.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hangs on an idle connection")
+	}
+}
+
+func TestRequestMine(t *testing.T) {
+	w := wallet.MakeWallet()
+	address := string(w.NetworkAddress(blockchain.RegTestParams.AddressVersion))
+	chain, _ := testChains(t, w)
+	node := startNode(t, chain)
+	var tx *blockchain.Transaction
+	err := node.View(func(chain *blockchain.Blockchain) error {
+		var err error
+		tx, err = blockchain.NewTransaction(w, address, 30, 2, &blockchain.UTXOSet{Blockchain: chain})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	mined, err := RequestMine(node.Address, address, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mined) != 2 || mined[0].Transactions != 2 || mined[1].Transactions != 1 {
+		t.Fatalf("node mined %+v, want the pending transaction in the first of 2 blocks", mined)
+	}
+	if subsidy := blockchain.RegTestParams.Subsidy(1); mined[0].Reward != subsidy+2 {
+		t.Fatalf("first block reward is %d, want %d", mined[0].Reward, subsidy+2)
+	}
+	if height(t, node) != 2 || node.Mempool.Count() != 0 {
+		t.Fatalf("node is at height %d with %d pending transactions", height(t, node), node.Mempool.Count())
+	}
+	if _, err := RequestMine(node.Address, "bogus", 1); err == nil {
+		t.Fatal("node mined to an invalid address")
+	}
+}
+
+// TestRequestUnspent sends a transaction the way the CLI does with a node,
+// from outputs the node returns instead of a local chain
+func TestRequestUnspent(t *testing.T) {
+	w := wallet.MakeWallet()
+	address := string(w.NetworkAddress(blockchain.RegTestParams.AddressVersion))
+	chain, _ := testChains(t, w)
+	node := startNode(t, chain)
+	unspent, err := RequestUnspent(node.Address, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unspent) != 1 || unspent[0].Value != blockchain.RegTestParams.Subsidy(0) {
+		t.Fatalf("node returned %+v, want the genesis coinbase", unspent)
+	}
+	tx, err := blockchain.SpendOutputs(w, address, 30, 1, unspent, &blockchain.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SendTransaction(node.Address, tx); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the transaction to reach the mempool", func() bool {
+		return node.Mempool.Has(tx.ID)
+	})
+	if unspent, err = RequestUnspent(node.Address, address); err != nil || len(unspent) != 0 {
+		t.Fatalf("node returned %+v, %v with the coinbase spent by its mempool", unspent, err)
+	}
+	if _, err := RequestUnspent(node.Address, "bogus"); err == nil {
+		t.Fatal("node returned outputs of an invalid address")
+	}
+}
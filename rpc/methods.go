@@ -0,0 +1,329 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/nd-sin/blockchain/blockchain"
+	"github.com/nd-sin/blockchain/wallet"
+	"sort"
+)
+
+// The views write hashes, keys and signatures in hex and outputs with the
+// address they pay on the network of the node
+
+type BlockView struct {
+	Hash          string   `json:"hash"`
+	PrevHash      string   `json:"prevhash"`
+	MerkleRoot    string   `json:"merkleroot"`
+	Version       int      `json:"version"`
+	Timestamp     int64    `json:"timestamp"`
+	Bits          int      `json:"bits"`
+	Nonce         int      `json:"nonce"`
+	Height        int      `json:"height"`
+	Confirmations int      `json:"confirmations"`
+	Signer        string   `json:"signer,omitempty"`
+	Transactions  []TxView `json:"transactions"`
+}
+
+type TxView struct {
+	ID      string       `json:"txid"`
+	Inputs  []InputView  `json:"inputs"`
+	Outputs []OutputView `json:"outputs"`
+}
+
+type InputView struct {
+	TxID      string `json:"txid"`
+	Out       int    `json:"vout"`
+	Signature string `json:"signature,omitempty"`
+	PubKey    string `json:"pubkey,omitempty"`
+}
+
+type OutputView struct {
+	Value      int    `json:"value"`
+	PubKeyHash string `json:"pubkeyhash"`
+	Address    string `json:"address"`
+}
+
+// TxResult is a transaction with the block holding it, pending transactions
+// have no block
+type TxResult struct {
+	Transaction   TxView `json:"transaction"`
+	BlockHash     string `json:"blockhash,omitempty"`
+	Height        int    `json:"height"`
+	Confirmations int    `json:"confirmations"`
+}
+
+type UTXOView struct {
+	TxID  string `json:"txid"`
+	Out   int    `json:"vout"`
+	Value int    `json:"value"`
+}
+
+func newTxView(tx *blockchain.Transaction, params *blockchain.NetworkParams) TxView {
+	view := TxView{ID: hex.EncodeToString(tx.ID)}
+	for _, in := range tx.Inputs {
+		view.Inputs = append(view.Inputs, InputView{
+			hex.EncodeToString(in.ID), in.Out, hex.EncodeToString(in.Signature), hex.EncodeToString(in.PubKey),
+		})
+	}
+	for _, out := range tx.Outputs {
+		view.Outputs = append(view.Outputs, OutputView{
+			out.Value, hex.EncodeToString(out.PubKeyHash), wallet.PubKeyHashAddress(out.PubKeyHash, params.AddressVersion),
+		})
+	}
+	return view
+}
+
+// confirmations counts the best chain blocks from block up to the tip, it is
+// -1 for blocks off the best chain
+func confirmations(chain *blockchain.Blockchain, block *blockchain.Block) (int, error) {
+	hash, err := chain.GetBlockHash(block.Height)
+	if err == blockchain.ErrBlockNotFound || err == nil && !bytes.Equal(hash, block.Hash) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	height, err := chain.Height()
+	if err != nil {
+		return 0, err
+	}
+	return height - block.Height + 1, nil
+}
+
+func newBlockView(block *blockchain.Block, confirmations int, params *blockchain.NetworkParams) BlockView {
+	view := BlockView{
+		Hash:          hex.EncodeToString(block.Hash),
+		PrevHash:      hex.EncodeToString(block.PrevHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		Version:       block.Version,
+		Timestamp:     block.Timestamp,
+		Bits:          block.Bits,
+		Nonce:         block.Nonce,
+		Height:        block.Height,
+		Confirmations: confirmations,
+		Signer:        hex.EncodeToString(block.Signer),
+	}
+	for _, tx := range block.Transactions {
+		view.Transactions = append(view.Transactions, newTxView(tx, params))
+	}
+	return view
+}
+
+func decodeHex(name, value string) ([]byte, error) {
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, &Error{CodeInvalidParams, fmt.Sprintf("%s must be non empty hex", name)}
+	}
+	return data, nil
+}
+
+func (s *Server) getBlock(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		Hash string `json:"hash"`
+	}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	hash, err := decodeHex("hash", args.Hash)
+	if err != nil {
+		return nil, err
+	}
+	var view BlockView
+	err = s.node.View(func(chain *blockchain.Blockchain) error {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		depth, err := confirmations(chain, block)
+		if err != nil {
+			return err
+		}
+		view = newBlockView(block, depth, chain.Params())
+		return nil
+	})
+	return view, err
+}
+
+func (s *Server) getBlockByHeight(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		Height *int `json:"height"`
+	}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	if args.Height == nil {
+		return nil, &Error{CodeInvalidParams, "height is required"}
+	}
+	var view BlockView
+	err := s.node.View(func(chain *blockchain.Blockchain) error {
+		block, err := chain.GetBlockByHeight(*args.Height)
+		if err != nil {
+			return fmt.Errorf("%w: height %d", err, *args.Height)
+		}
+		depth, err := confirmations(chain, block)
+		if err != nil {
+			return err
+		}
+		view = newBlockView(block, depth, chain.Params())
+		return nil
+	})
+	return view, err
+}
+
+func (s *Server) getTransaction(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		TxID string `json:"txid"`
+	}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	id, err := decodeHex("txid", args.TxID)
+	if err != nil {
+		return nil, err
+	}
+	var result TxResult
+	err = s.node.View(func(chain *blockchain.Blockchain) error {
+		if tx, ok := s.node.Mempool.Get(id); ok {
+			result.Transaction = newTxView(tx, chain.Params())
+			return nil
+		}
+		block, err := chain.FindTransactionBlock(id)
+		if err != nil {
+			return err
+		}
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				result.Transaction = newTxView(tx, chain.Params())
+			}
+		}
+		result.BlockHash = hex.EncodeToString(block.Hash)
+		result.Height = block.Height
+		result.Confirmations, err = confirmations(chain, block)
+		return err
+	})
+	return result, err
+}
+
+// unspentOutputs returns the unspent outputs of an address of the node
+// network
+func (s *Server) unspentOutputs(params json.RawMessage) (string, []blockchain.UnspentOutput, error) {
+	var args struct {
+		Address string `json:"address"`
+	}
+	if err := decodeParams(params, &args); err != nil {
+		return "", nil, err
+	}
+	var unspent []blockchain.UnspentOutput
+	err := s.node.View(func(chain *blockchain.Blockchain) error {
+		if err := wallet.CheckAddress(args.Address, chain.Params().AddressVersion); err != nil {
+			return fmt.Errorf("%w: %s", err, args.Address)
+		}
+		pubKeyHash, err := wallet.AddressPubKeyHash(args.Address)
+		if err != nil {
+			return err
+		}
+		UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+		unspent, err = UTXOSet.FindUnspentOutputs(pubKeyHash)
+		return err
+	})
+	return args.Address, unspent, err
+}
+
+func (s *Server) getBalance(params json.RawMessage) (interface{}, error) {
+	address, unspent, err := s.unspentOutputs(params)
+	if err != nil {
+		return nil, err
+	}
+	balance := 0
+	for _, out := range unspent {
+		balance += out.Value
+	}
+	return struct {
+		Address string `json:"address"`
+		Balance int    `json:"balance"`
+	}{address, balance}, nil
+}
+
+func (s *Server) getUTXOs(params json.RawMessage) (interface{}, error) {
+	_, unspent, err := s.unspentOutputs(params)
+	if err != nil {
+		return nil, err
+	}
+	views := []UTXOView{}
+	for _, out := range unspent {
+		views = append(views, UTXOView{hex.EncodeToString(out.TxID), out.Index, out.Value})
+	}
+	return views, nil
+}
+
+// sendRawTransaction takes a signed transaction serialized the way the
+// network sends it, written in hex
+func (s *Server) sendRawTransaction(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		Hex string `json:"hex"`
+	}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	data, err := decodeHex("hex", args.Hex)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := blockchain.DeserializeTransaction(data)
+	if err != nil {
+		return nil, &Error{CodeInvalidParams, fmt.Sprintf("malformed transaction: %s", err)}
+	}
+	if err := s.node.SubmitTransaction(tx); err != nil {
+		return nil, err
+	}
+	return struct {
+		TxID string `json:"txid"`
+	}{hex.EncodeToString(tx.ID)}, nil
+}
+
+func (s *Server) listAddresses(params json.RawMessage) (interface{}, error) {
+	if err := decodeParams(params, &struct{}{}); err != nil {
+		return nil, err
+	}
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+	addresses := s.wallets.GetAllWallets()
+	if addresses == nil {
+		addresses = []string{}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// createWallet derives the next address and saves the wallet file. The
+// recovery phrase the first address creates is never sent over RPC, the
+// wallet mnemonic command prints it
+func (s *Server) createWallet(params json.RawMessage) (interface{}, error) {
+	if err := decodeParams(params, &struct{}{}); err != nil {
+		return nil, err
+	}
+	s.walletMu.Lock()
+	defer s.walletMu.Unlock()
+	if !s.wallets.HasSeed() {
+		mnemonic, err := wallet.NewMnemonic(128)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.wallets.SetMnemonic(mnemonic); err != nil {
+			return nil, err
+		}
+	}
+	address, err := s.wallets.AddWallet()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.wallets.SaveFile(); err != nil {
+		return nil, err
+	}
+	return struct {
+		Address string `json:"address"`
+	}{address}, nil
+}
@@ -0,0 +1,211 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nd-sin/blockchain/blockchain"
+	"github.com/nd-sin/blockchain/network"
+	"github.com/nd-sin/blockchain/wallet"
+	"golang.org/x/net/websocket"
+	"mime"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	jsonRPCVersion  = "2.0"
+	maxRequestSize  = 4 << 20
+	shutdownTimeout = 5 * time.Second
+)
+
+// JSON-RPC 2.0 error codes, the negative ones above -32100 are ours
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeNotFound       = -32001
+	CodeInvalidAddress = -32002
+	CodeRejected       = -32003
+	CodeWallet         = -32004
+)
+
+// Error is the error member of a JSON-RPC response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type handler func(s *Server, params json.RawMessage) (interface{}, error)
+
+var methods = map[string]handler{
+	"getblock":           (*Server).getBlock,
+	"getblockbyheight":   (*Server).getBlockByHeight,
+	"gettransaction":     (*Server).getTransaction,
+	"getbalance":         (*Server).getBalance,
+	"sendrawtransaction": (*Server).sendRawTransaction,
+	"listaddresses":      (*Server).listAddresses,
+	"createwallet":       (*Server).createWallet,
+	"getutxos":           (*Server).getUTXOs,
+}
+
+// Server answers JSON-RPC requests over HTTP from the chain and mempool of a
+// node and from a wallet file, which must be unlocked to create wallets.
+// WebSocket clients connect to /ws to subscribe to chain events
+type Server struct {
+	Address string
+
+	node    *network.Node
+	wallets *wallet.Wallets
+	// walletMu guards the wallet file, the node guards its chain
+	walletMu sync.Mutex
+	events   *blockchain.EventBus
+	params   *blockchain.NetworkParams
+	http     *http.Server
+	done     chan error
+	quit     chan struct{}
+	// wg tracks the WebSocket clients, which Shutdown does not wait for
+	wg sync.WaitGroup
+}
+
+func NewServer(address string, node *network.Node, wallets *wallet.Wallets) *Server {
+	s := &Server{Address: address, node: node, wallets: wallets, quit: make(chan struct{})}
+	_ = node.View(func(chain *blockchain.Blockchain) error {
+		s.events, s.params = chain.Events(), chain.Params()
+		return nil
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleHTTP)
+	mux.Handle("/ws", websocket.Server{Handshake: checkOrigin, Handler: s.handleWebSocket})
+	s.http = &http.Server{Handler: mux}
+	return s
+}
+
+// Start listens on the server address, an address with port 0 is replaced by
+// the port actually bound
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+	s.Address = ln.Addr().String()
+	s.done = make(chan error, 1)
+	go func() {
+		s.done <- s.http.Serve(ln)
+	}()
+	return nil
+}
+
+// Close stops accepting requests, waits for the ones in flight to finish and
+// disconnects the WebSocket clients
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	close(s.quit)
+	err := s.http.Shutdown(ctx)
+	if serveErr := <-s.done; serveErr != http.ErrServerClosed && err == nil {
+		err = serveErr
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	// browsers send forms cross origin without asking, but not JSON
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "JSON-RPC requests must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req Request
+	resp := Response{JSONRPC: jsonRPCVersion}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err := decoder.Decode(&req); err != nil {
+		resp.Error = &Error{CodeParseError, err.Error()}
+	} else if resp.ID = req.ID; req.JSONRPC != jsonRPCVersion {
+		resp.Error = &Error{CodeInvalidRequest, "jsonrpc must be " + jsonRPCVersion}
+	} else {
+		resp.Result, resp.Error = s.Call(req.Method, req.Params)
+	}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// Call runs a method the way a request would
+func (s *Server) Call(method string, params json.RawMessage) (interface{}, *Error) {
+	h, ok := methods[method]
+	if !ok {
+		return nil, &Error{CodeMethodNotFound, "method not found: " + method}
+	}
+	result, err := h(s, params)
+	if err != nil {
+		return nil, toError(err)
+	}
+	return result, nil
+}
+
+func toError(err error) *Error {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	code := CodeInternalError
+	switch {
+	case errors.Is(err, blockchain.ErrBlockNotFound), errors.Is(err, blockchain.ErrTxNotFound):
+		code = CodeNotFound
+	case errors.Is(err, wallet.ErrInvalidAddress), errors.Is(err, wallet.ErrWrongNetwork):
+		code = CodeInvalidAddress
+	case errors.Is(err, blockchain.ErrInvalidSignature), errors.Is(err, blockchain.ErrInvalidValue), errors.Is(err, blockchain.ErrValueOutOfRange),
+		errors.Is(err, blockchain.ErrDoubleSpend), errors.Is(err, blockchain.ErrOutputSpent),
+		errors.Is(err, blockchain.ErrCoinbaseTx), errors.Is(err, blockchain.ErrInvalidPrevTx),
+		errors.Is(err, blockchain.ErrBadTxID), errors.Is(err, blockchain.ErrBadTransaction):
+		code = CodeRejected
+	case errors.Is(err, wallet.ErrWalletLocked), errors.Is(err, wallet.ErrNoPassphrase):
+		code = CodeWallet
+	}
+	return &Error{code, err.Error()}
+}
+
+// decodeParams reads the named parameters of a request, requests without
+// parameters leave v untouched
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return &Error{CodeInvalidParams, err.Error()}
+	}
+	return nil
+}
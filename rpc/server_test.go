@@ -0,0 +1,385 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nd-sin/blockchain/blockchain"
+	"github.com/nd-sin/blockchain/network"
+	"github.com/nd-sin/blockchain/wallet"
+)
+
+// testServer is a server over a regtest node whose genesis block pays
+// 100 coins to miner, requests go through an httptest server
+type testServer struct {
+	*Server
+	http    *httptest.Server
+	node    *network.Node
+	miner   *wallet.Wallet
+	address string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	miner := wallet.MakeWallet()
+	address := string(miner.NetworkAddress(blockchain.RegTestParams.AddressVersion))
+	chain, err := blockchain.NewTestChain(blockchain.Options{DataDir: t.TempDir()}, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Close() })
+	node := network.NewNode("127.0.0.1:0", chain)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	wallets, err := wallet.CreateWallets(chain.Options.Dir(), blockchain.RegTestParams.AddressVersion)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if err := wallets.SetPassphrase("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("127.0.0.1:0", node, wallets)
+	ts := httptest.NewServer(s.http.Handler)
+	t.Cleanup(ts.Close)
+	return &testServer{s, ts, node, miner, address}
+}
+
+type testResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func (ts *testServer) post(t *testing.T, body string) testResponse {
+	t.Helper()
+	resp, err := http.Post(ts.http.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: status %s", body, resp.Status)
+	}
+	var r testResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.JSONRPC != jsonRPCVersion {
+		t.Fatalf("%s: jsonrpc %q", body, r.JSONRPC)
+	}
+	return r
+}
+
+// call runs a method that must succeed and decodes its result into result
+func (ts *testServer) call(t *testing.T, method string, params interface{}, result interface{}) {
+	t.Helper()
+	r := ts.request(t, method, params)
+	if r.Error != nil {
+		t.Fatalf("%s: error %d %s", method, r.Error.Code, r.Error.Message)
+	}
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		t.Fatalf("%s: %s in %s", method, err, r.Result)
+	}
+}
+
+// callError runs a method that must fail with code
+func (ts *testServer) callError(t *testing.T, method string, params interface{}, code int) {
+	t.Helper()
+	r := ts.request(t, method, params)
+	if r.Error == nil || r.Error.Code != code {
+		t.Fatalf("%s %v: got error %+v with result %s, want code %d", method, params, r.Error, r.Result, code)
+	}
+}
+
+func (ts *testServer) request(t *testing.T, method string, params interface{}) testResponse {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 7, "method": method, "params": params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ts.post(t, string(data))
+	if string(r.ID) != "7" {
+		t.Fatalf("%s: response id %s", method, r.ID)
+	}
+	return r
+}
+
+// spend signs a transaction paying amount from the miner to itself
+func (ts *testServer) spend(t *testing.T, amount, fee int) *blockchain.Transaction {
+	t.Helper()
+	var tx *blockchain.Transaction
+	err := ts.node.View(func(chain *blockchain.Blockchain) error {
+		var err error
+		tx, err = blockchain.NewTransaction(ts.miner, ts.address, amount, fee, &blockchain.UTXOSet{Blockchain: chain})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// mine mines txs, which must be pending, with a coinbase paying the miner
+func (ts *testServer) mine(t *testing.T, txs ...*blockchain.Transaction) *blockchain.Block {
+	t.Helper()
+	var reward int
+	err := ts.node.View(func(chain *blockchain.Blockchain) error {
+		var err error
+		reward, err = chain.NextSubsidy()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range txs {
+		fee, _ := ts.node.Mempool.Fee(tx.ID)
+		reward += fee
+	}
+	coinbase, err := blockchain.CoinbaseTx(ts.address, "", reward, &blockchain.RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ts.node.AddBlock(append([]*blockchain.Transaction{coinbase}, txs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestBlocks(t *testing.T) {
+	ts := newTestServer(t)
+	var genesis BlockView
+	ts.call(t, "getblockbyheight", map[string]int{"height": 0}, &genesis)
+	if genesis.Height != 0 || genesis.Confirmations != 1 || len(genesis.Transactions) != 1 {
+		t.Fatalf("genesis %+v", genesis)
+	}
+	if out := genesis.Transactions[0].Outputs[0]; out.Address != ts.address || out.Value != 100 {
+		t.Fatalf("genesis pays %+v", out)
+	}
+	block := ts.mine(t)
+
+	var view BlockView
+	ts.call(t, "getblock", map[string]string{"hash": genesis.Hash}, &view)
+	if view.Hash != genesis.Hash || view.Confirmations != 2 {
+		t.Fatalf("getblock returned %s with %d confirmations", view.Hash, view.Confirmations)
+	}
+	ts.call(t, "getblockbyheight", map[string]int{"height": 1}, &view)
+	if view.Hash != hex.EncodeToString(block.Hash) || view.PrevHash != genesis.Hash || view.Confirmations != 1 {
+		t.Fatalf("block 1 is %+v", view)
+	}
+
+	// a block at the same height as the tip stays off the best chain
+	var side *blockchain.Block
+	err := ts.node.View(func(chain *blockchain.Blockchain) error {
+		parent, err := chain.GetBlockIndex(block.PrevHash)
+		if err != nil {
+			return err
+		}
+		coinbase, err := blockchain.CoinbaseTx(ts.address, "", 1, chain.Params())
+		if err != nil {
+			return err
+		}
+		side = blockchain.NewBlock([]*blockchain.Transaction{coinbase}, block.PrevHash, 1, 0)
+		if err := chain.Consensus().Prepare(chain, &side.BlockHeader, parent); err != nil {
+			return err
+		}
+		if err := chain.SealBlock(context.Background(), side, blockchain.MineOptions{}); err != nil {
+			return err
+		}
+		_, err = chain.AcceptBlock(side)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.call(t, "getblock", map[string]string{"hash": hex.EncodeToString(side.Hash)}, &view)
+	if view.Confirmations != -1 {
+		t.Fatalf("side block has %d confirmations, want -1", view.Confirmations)
+	}
+
+	ts.callError(t, "getblock", map[string]string{"hash": strings.Repeat("00", 32)}, CodeNotFound)
+	ts.callError(t, "getblock", map[string]string{"hash": "zz"}, CodeInvalidParams)
+	ts.callError(t, "getblockbyheight", map[string]int{"height": 5}, CodeNotFound)
+	ts.callError(t, "getblockbyheight", map[string]int{}, CodeInvalidParams)
+}
+
+func TestTransactions(t *testing.T) {
+	ts := newTestServer(t)
+	tx := ts.spend(t, 30, 2)
+	var sent struct {
+		TxID string `json:"txid"`
+	}
+	ts.call(t, "sendrawtransaction", map[string]string{"hex": hex.EncodeToString(tx.Serialize())}, &sent)
+	if sent.TxID != hex.EncodeToString(tx.ID) {
+		t.Fatalf("sendrawtransaction returned %s", sent.TxID)
+	}
+	if !ts.node.Mempool.Has(tx.ID) {
+		t.Fatal("transaction is not pending")
+	}
+
+	var result TxResult
+	ts.call(t, "gettransaction", map[string]string{"txid": sent.TxID}, &result)
+	if result.Transaction.ID != sent.TxID || result.BlockHash != "" || result.Confirmations != 0 {
+		t.Fatalf("pending transaction %+v", result)
+	}
+
+	// another transaction spending the same output is a double spend
+	conflict := ts.spend(t, 40, 1)
+	ts.callError(t, "sendrawtransaction", map[string]string{"hex": hex.EncodeToString(conflict.Serialize())}, CodeRejected)
+	ts.callError(t, "sendrawtransaction", map[string]string{"hex": "00"}, CodeInvalidParams)
+
+	block := ts.mine(t, tx)
+	ts.call(t, "gettransaction", map[string]string{"txid": sent.TxID}, &result)
+	if result.BlockHash != hex.EncodeToString(block.Hash) || result.Height != 1 || result.Confirmations != 1 {
+		t.Fatalf("mined transaction %+v", result)
+	}
+	ts.callError(t, "gettransaction", map[string]string{"txid": strings.Repeat("ab", 32)}, CodeNotFound)
+}
+
+func TestBalance(t *testing.T) {
+	ts := newTestServer(t)
+	tx := ts.spend(t, 30, 2)
+	if err := ts.node.SubmitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	ts.mine(t, tx)
+
+	var balance struct {
+		Address string `json:"address"`
+		Balance int    `json:"balance"`
+	}
+	ts.call(t, "getbalance", map[string]string{"address": ts.address}, &balance)
+	// the genesis reward, less the fee, plus the block 1 reward with the fee
+	if want := 100 - 2 + blockchain.RegTestParams.Subsidy(1) + 2; balance.Address != ts.address || balance.Balance != want {
+		t.Fatalf("getbalance returned %+v, want %d", balance, want)
+	}
+	var utxos []UTXOView
+	ts.call(t, "getutxos", map[string]string{"address": ts.address}, &utxos)
+	total := 0
+	for _, utxo := range utxos {
+		total += utxo.Value
+	}
+	if len(utxos) != 3 || total != balance.Balance {
+		t.Fatalf("getutxos returned %+v", utxos)
+	}
+
+	other := string(wallet.MakeWallet().NetworkAddress(blockchain.RegTestParams.AddressVersion))
+	ts.call(t, "getutxos", map[string]string{"address": other}, &utxos)
+	if len(utxos) != 0 {
+		t.Fatalf("new address has outputs %+v", utxos)
+	}
+	mainAddress := string(ts.miner.NetworkAddress(blockchain.MainNetParams.AddressVersion))
+	ts.callError(t, "getbalance", map[string]string{"address": mainAddress}, CodeInvalidAddress)
+	ts.callError(t, "getutxos", map[string]string{"address": "bogus"}, CodeInvalidAddress)
+}
+
+func TestWallets(t *testing.T) {
+	ts := newTestServer(t)
+	var addresses []string
+	ts.call(t, "listaddresses", nil, &addresses)
+	if len(addresses) != 0 {
+		t.Fatalf("new wallet file lists %v", addresses)
+	}
+	var created map[string]string
+	ts.call(t, "createwallet", nil, &created)
+	if len(created) != 1 || !wallet.ValidateAddress(created["address"], blockchain.RegTestParams.AddressVersion) {
+		t.Fatalf("createwallet returned %v", created)
+	}
+	ts.call(t, "listaddresses", nil, &addresses)
+	if len(addresses) != 1 || addresses[0] != created["address"] {
+		t.Fatalf("listaddresses returned %v", addresses)
+	}
+	ts.callError(t, "listaddresses", map[string]int{"count": 1}, CodeInvalidParams)
+
+	if err := ts.wallets.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	ts.callError(t, "createwallet", nil, CodeWallet)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	ts := newTestServer(t)
+	for body, code := range map[string]int{
+		`{"jsonrpc":"2.0","id":1,"method":`:                                             CodeParseError,
+		`{"jsonrpc":"1.0","id":1,"method":"listaddresses"}`:                             CodeInvalidRequest,
+		`{"jsonrpc":"2.0","id":1,"method":"nosuchmethod"}`:                              CodeMethodNotFound,
+		`{"jsonrpc":"2.0","id":1,"method":"getblock","params":{"hash":"00","extra":1}}`: CodeInvalidParams,
+		`{"jsonrpc":"2.0","id":1,"method":"getblockbyheight","params":[0]}`:             CodeInvalidParams,
+	} {
+		r := ts.post(t, body)
+		if r.Error == nil || r.Error.Code != code {
+			t.Errorf("%s: got error %+v, want code %d", body, r.Error, code)
+		}
+	}
+	if r := ts.post(t, `{"jsonrpc":"2.0","method":`); string(r.ID) != "null" {
+		t.Errorf("unparsed request answered with id %s", r.ID)
+	}
+
+	resp, err := http.Get(ts.http.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Fatalf("GET answered %s, Allow %q", resp.Status, resp.Header.Get("Allow"))
+	}
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"createwallet"}`
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		req, err := http.NewRequest(http.MethodPost, ts.http.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("content type %q answered %s", contentType, resp.Status)
+		}
+	}
+	if addresses := ts.wallets.GetAllWallets(); len(addresses) != 0 {
+		t.Fatalf("rejected requests created %v", addresses)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ts := newTestServer(t)
+	if err := ts.Start(); err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ts.Address
+	body := `{"jsonrpc":"2.0","id":1,"method":"listaddresses"}`
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r testResponse
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	resp.Body.Close()
+	if err != nil || r.Error != nil {
+		t.Fatalf("request before Close: %v %+v", err, r.Error)
+	}
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	client := http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if resp, err := client.Post(url, "application/json", bytes.NewReader([]byte(body))); err == nil {
+		resp.Body.Close()
+		t.Fatal("server answers after Close")
+	}
+}
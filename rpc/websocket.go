@@ -0,0 +1,198 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nd-sin/blockchain/blockchain"
+	"github.com/nd-sin/blockchain/wallet"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// eventBuffer is how many events a WebSocket client may fall behind
+	// before it is disconnected
+	eventBuffer = 256
+	// writeTimeout disconnects clients that stop reading
+	writeTimeout = 10 * time.Second
+)
+
+var errCrossOrigin = errors.New("WebSocket origin does not match the host")
+
+// Notification is a JSON-RPC request without an ID, the server sends one per
+// event a WebSocket client subscribed to
+type Notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// EventView is an event as clients see it, block events name the block and
+// transaction events carry the transaction along with the block confirming
+// it
+type EventView struct {
+	Event       blockchain.EventType `json:"event"`
+	BlockHash   string               `json:"blockhash,omitempty"`
+	PrevHash    string               `json:"prevhash,omitempty"`
+	Height      int                  `json:"height,omitempty"`
+	Transaction *TxView              `json:"transaction,omitempty"`
+}
+
+// eventFilter is what a WebSocket client subscribed to, all blocks and the
+// transactions paying or spending from a set of addresses
+type eventFilter struct {
+	blocks       bool
+	pubKeyHashes map[string]bool
+}
+
+func (f *eventFilter) match(event blockchain.Event) bool {
+	if event.Tx == nil {
+		return f.blocks
+	}
+	for _, out := range event.Tx.Outputs {
+		if f.pubKeyHashes[string(out.PubKeyHash)] {
+			return true
+		}
+	}
+	if event.Tx.IsCoinbase() {
+		return false
+	}
+	for _, in := range event.Tx.Inputs {
+		if f.pubKeyHashes[string(wallet.PublicKeyHash(in.PubKey))] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin turns away the pages of other sites, browsers let any page open
+// a WebSocket and always say where it comes from. Clients that are not
+// browsers may send no origin
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil && origin.Host != r.Host {
+		return errCrossOrigin
+	}
+	config.Origin = origin
+	return nil
+}
+
+func newEventView(event blockchain.Event, params *blockchain.NetworkParams) EventView {
+	view := EventView{Event: event.Type}
+	if event.Block != nil {
+		view.BlockHash = hex.EncodeToString(event.Block.Hash)
+		view.Height = event.Block.Height
+		if event.Tx == nil {
+			view.PrevHash = hex.EncodeToString(event.Block.PrevHash)
+		}
+	}
+	if event.Tx != nil {
+		tx := newTxView(event.Tx, params)
+		view.Transaction = &tx
+	}
+	return view
+}
+
+// handleWebSocket serves the subscribe method and pushes the events matching
+// the latest subscription until the client leaves, falls behind or the
+// server closes
+func (s *Server) handleWebSocket(ws *websocket.Conn) {
+	s.wg.Add(1)
+	defer s.wg.Done()
+	defer ws.Close()
+	sub := s.events.Subscribe(eventBuffer)
+	defer sub.Unsubscribe()
+
+	var mu sync.Mutex
+	filter := &eventFilter{}
+	send := func(v interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			return err
+		}
+		return websocket.JSON.Send(ws, v)
+	}
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		for {
+			var req Request
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				return
+			}
+			resp := Response{JSONRPC: jsonRPCVersion, ID: req.ID}
+			next, err := s.subscribe(req)
+			if err != nil {
+				resp.Error = toError(err)
+			} else {
+				mu.Lock()
+				filter = next
+				mu.Unlock()
+				resp.Result = true
+			}
+			if resp.ID == nil {
+				resp.ID = json.RawMessage("null")
+			}
+			if send(resp) != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				_ = send(Notification{jsonRPCVersion, "error", "too many events pending, subscribe again"})
+				return
+			}
+			mu.Lock()
+			match := filter.match(event)
+			mu.Unlock()
+			if match && send(Notification{jsonRPCVersion, "event", newEventView(event, s.params)}) != nil {
+				return
+			}
+		case <-received:
+			return
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// subscribe reads the filter of a subscribe request, it replaces the one the
+// client had
+func (s *Server) subscribe(req Request) (*eventFilter, error) {
+	if req.JSONRPC != jsonRPCVersion {
+		return nil, &Error{CodeInvalidRequest, "jsonrpc must be " + jsonRPCVersion}
+	}
+	if req.Method != "subscribe" {
+		return nil, &Error{CodeMethodNotFound, "method not found: " + req.Method}
+	}
+	var args struct {
+		Blocks    bool     `json:"blocks"`
+		Addresses []string `json:"addresses"`
+	}
+	if err := decodeParams(req.Params, &args); err != nil {
+		return nil, err
+	}
+	filter := &eventFilter{args.Blocks, make(map[string]bool)}
+	for _, address := range args.Addresses {
+		if err := wallet.CheckAddress(address, s.params.AddressVersion); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, address)
+		}
+		pubKeyHash, err := wallet.AddressPubKeyHash(address)
+		if err != nil {
+			return nil, err
+		}
+		filter.pubKeyHashes[string(pubKeyHash)] = true
+	}
+	return filter, nil
+}
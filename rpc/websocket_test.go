@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// dial opens a WebSocket to the server at url
+func dial(url, origin string) (*websocket.Conn, error) {
+	return websocket.Dial("ws://"+strings.TrimPrefix(url, "http://")+"/ws", "", origin)
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	ts := newTestServer(t)
+	if ws, err := dial(ts.http.URL, "http://example.com"); err == nil {
+		ws.Close()
+		t.Fatal("cross origin WebSocket accepted")
+	}
+	ws, err := dial(ts.http.URL, ts.http.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+}
+
+func TestWebSocketBlocks(t *testing.T) {
+	ts := newTestServer(t)
+	if err := ts.Start(); err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ts.Address
+	ws, err := dial(url, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	req := Request{jsonRPCVersion, "subscribe", json.RawMessage(`{"blocks":true}`), json.RawMessage("1")}
+	if err := websocket.JSON.Send(ws, req); err != nil {
+		t.Fatal(err)
+	}
+	var resp testResponse
+	if err := websocket.JSON.Receive(ws, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil || string(resp.Result) != "true" {
+		t.Fatalf("subscribe returned %s, error %+v", resp.Result, resp.Error)
+	}
+
+	block := ts.mine(t)
+	var notification struct {
+		Method string    `json:"method"`
+		Params EventView `json:"params"`
+	}
+	if err := websocket.JSON.Receive(ws, &notification); err != nil {
+		t.Fatal(err)
+	}
+	event := notification.Params
+	if notification.Method != "event" || event.Event != "blockconnected" || event.BlockHash != hex.EncodeToString(block.Hash) {
+		t.Fatalf("got %+v, want block %x connected", notification, block.Hash)
+	}
+
+	// Close disconnects subscribers
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := websocket.JSON.Receive(ws, &notification); err == nil {
+		t.Fatalf("received %+v after Close", notification)
+	}
+}
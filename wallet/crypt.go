@@ -0,0 +1,135 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedMagic starts encrypted wallet files, files without it are the
+// plaintext files written before encryption
+var encryptedMagic = []byte("WALLETENC1")
+
+// scrypt costs of new files, the ones used are stored in each file
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// bounds on the scrypt costs read from a file, so a crafted file cannot
+// make unlocking exhaust memory or time
+const (
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 256 << 20
+)
+
+var (
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	ErrCorruptWallet   = errors.New("wallet file is corrupt")
+)
+
+// sealedWallets is an encrypted wallet file. Addresses is kept in clear so
+// locked wallets can still be listed, Data is the gob encoded wallets sealed
+// with AES-256-GCM under a key derived from the passphrase with scrypt. The
+// addresses are authenticated along with Data
+type sealedWallets struct {
+	Addresses []string
+	Salt      []byte
+	N, R, P   int
+	Nonce     []byte
+	Data      []byte
+}
+
+func deriveKey(passphrase string, salt []byte, n, r, p int) ([]byte, error) {
+	if n < 2 || n > maxScryptN || n&(n-1) != 0 || r < 1 || r > maxScryptR || p < 1 || p > maxScryptP ||
+		128*n*r > maxScryptMemory {
+		return nil, fmt.Errorf("%w: scrypt costs N=%d r=%d p=%d", ErrCorruptWallet, n, r, p)
+	}
+	return scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+}
+
+// additionalData is what GCM authenticates besides the ciphertext, the magic
+// and every address prefixed with its length
+func (sealed *sealedWallets) additionalData() []byte {
+	data := append([]byte{}, encryptedMagic...)
+	var length [binary.MaxVarintLen64]byte
+	for _, address := range sealed.Addresses {
+		data = append(data, length[:binary.PutUvarint(length[:], uint64(len(address)))]...)
+		data = append(data, address...)
+	}
+	return data
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a fresh salt and nonce
+func seal(passphrase string, addresses []string, plaintext []byte) ([]byte, error) {
+	sealed := sealedWallets{Addresses: addresses, N: scryptN, R: scryptR, P: scryptP}
+	sealed.Salt = make([]byte, 16)
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, sealed.Salt, sealed.N, sealed.R, sealed.P)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Data = gcm.Seal(nil, sealed.Nonce, plaintext, sealed.additionalData())
+	content := bytes.NewBuffer(append([]byte{}, encryptedMagic...))
+	if err := gob.NewEncoder(content).Encode(sealed); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+func decodeSealed(content []byte) (*sealedWallets, error) {
+	var sealed sealedWallets
+	decoder := gob.NewDecoder(bytes.NewReader(content[len(encryptedMagic):]))
+	if err := decoder.Decode(&sealed); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+// open decrypts the wallets, a file whose addresses were changed fails as if
+// the passphrase was wrong
+func (sealed *sealedWallets) open(passphrase string) ([]byte, error) {
+	key, err := deriveKey(passphrase, sealed.Salt, sealed.N, sealed.R, sealed.P)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed.Nonce) != gcm.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	plaintext, err := gcm.Open(nil, sealed.Nonce, sealed.Data, sealed.additionalData())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
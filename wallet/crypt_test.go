@@ -0,0 +1,92 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// rewrite changes the clear part of a sealed wallet file
+func rewrite(t *testing.T, file string, change func(*sealedWallets)) {
+	t.Helper()
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := decodeSealed(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(sealed)
+	buf := bytes.NewBuffer(append([]byte{}, encryptedMagic...))
+	if err := gob.NewEncoder(buf).Encode(sealed); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSealedAddressesAuthenticated(t *testing.T) {
+	for name, change := range map[string]func(*sealedWallets){
+		"unchanged": func(s *sealedWallets) {},
+		"added":     func(s *sealedWallets) { s.Addresses = append(s.Addresses, string(MakeWallet().Address())) },
+		"removed":   func(s *sealedWallets) { s.Addresses = s.Addresses[1:] },
+		"swapped":   func(s *sealedWallets) { s.Addresses[0] = string(MakeWallet().Address()) },
+		// moving a byte between two addresses keeps their concatenation
+		"split": func(s *sealedWallets) {
+			s.Addresses[0], s.Addresses[1] = s.Addresses[0]+s.Addresses[1][:1], s.Addresses[1][1:]
+		},
+	} {
+		dir := t.TempDir()
+		wallets, err := CreateWallets(dir, MainNetVersion)
+		if err == nil {
+			t.Fatal("wallet file found in an empty directory")
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := wallets.AddWallet(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := wallets.SetPassphrase("passphrase"); err != nil {
+			t.Fatal(err)
+		}
+		if err := wallets.SaveFile(); err != nil {
+			t.Fatal(err)
+		}
+		rewrite(t, filepath.Join(dir, walletFile), change)
+		wallets, err = CreateWallets(dir, MainNetVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want error
+		if name != "unchanged" {
+			want = ErrWrongPassphrase
+		}
+		if err := wallets.Unlock("passphrase"); !errors.Is(err, want) {
+			t.Errorf("%s addresses: Unlock returned %v, want %v", name, err, want)
+		}
+	}
+}
+
+func TestScryptCostBounds(t *testing.T) {
+	sealed := &sealedWallets{Salt: make([]byte, 16)}
+	for _, cost := range [][3]int{
+		{0, 8, 1},
+		{1000, 8, 1},
+		{1 << 30, 8, 1},
+		{1 << 20, 8, 1},
+		{1 << 15, 0, 1},
+		{1 << 15, 1 << 20, 1},
+		{1 << 15, 8, 0},
+		{1 << 15, 8, 1 << 20},
+	} {
+		sealed.N, sealed.R, sealed.P = cost[0], cost[1], cost[2]
+		if _, err := sealed.open("passphrase"); !errors.Is(err, ErrCorruptWallet) {
+			t.Errorf("N=%d r=%d p=%d: open returned %v, want %v", cost[0], cost[1], cost[2], err, ErrCorruptWallet)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HardenedOffset marks hardened child indexes, written with a trailing '
+// in paths
+const HardenedOffset = uint32(1) << 31
+
+// DefaultAccountPath is the BIP-44 shaped branch addresses are derived from,
+// the address index is appended to it
+const DefaultAccountPath = "m/44'/0'/0'/0"
+
+// masterKeySalt is the SLIP-10 HMAC key of P-256 master keys
+var masterKeySalt = []byte("Nist256p1 seed")
+
+var ErrInvalidPath = errors.New("invalid derivation path")
+
+// ExtendedKey is a private key of the BIP-32 key tree. P-256 has no BIP-32
+// of its own, derivation follows SLIP-10, which is BIP-32 with the curve
+// order swapped and invalid keys derived again instead of skipped
+type ExtendedKey struct {
+	Key       []byte
+	ChainCode []byte
+}
+
+// NewMasterKey derives the root of the key tree from a seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, masterKeySalt)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	n := elliptic.P256().Params().N
+	for {
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return &ExtendedKey{sum[:32], sum[32:]}, nil
+		}
+		mac := hmac.New(sha512.New, masterKeySalt)
+		mac.Write(sum)
+		sum = mac.Sum(nil)
+	}
+}
+
+// Child derives child index, indexes from HardenedOffset up are hardened and
+// do not depend on the public key
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0}, k.Key...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.Key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = appendIndex(data, index)
+	for {
+		mac := hmac.New(sha512.New, k.ChainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(n) < 0 {
+			child := tweak.Add(tweak, new(big.Int).SetBytes(k.Key))
+			child.Mod(child, n)
+			if child.Sign() != 0 {
+				return &ExtendedKey{child.FillBytes(make([]byte, keyLength)), sum[32:]}, nil
+			}
+		}
+		data = appendIndex(append([]byte{1}, sum[32:]...), index)
+	}
+}
+
+func appendIndex(data []byte, index uint32) []byte {
+	var ser [4]byte
+	binary.BigEndian.PutUint32(ser[:], index)
+	return append(data, ser[:]...)
+}
+
+// Derive walks a path such as m/44'/0'/0'/0/3 from the master key
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPath, path)
+	}
+	key := k
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(part, "'") {
+			offset = HardenedOffset
+			part = strings.TrimSuffix(part, "'")
+		}
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPath, path)
+		}
+		if key, err = key.Child(uint32(index) + offset); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func (k *ExtendedKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	return DecodePrivateKey(k.Key)
+}
+
+// DeriveWallet derives the wallet at path from a seed
+func DeriveWallet(seed []byte, path string) (*Wallet, error) {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	key, err := master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	private, err := key.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{*private, EncodePublicKey(&private.PublicKey)}, nil
+}
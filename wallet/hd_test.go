@@ -0,0 +1,58 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestDeriveVectors checks test vector 1 of SLIP-10 for nist256p1
+func TestDeriveVectors(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		path, chainCode, key string
+	}{
+		{
+			"m",
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+		},
+		{
+			"m/0'",
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+		},
+		{
+			"m/0'/1",
+			"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+		},
+	} {
+		key, err := master.Derive(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chainCode := hex.EncodeToString(key.ChainCode); chainCode != test.chainCode {
+			t.Errorf("%s: got chain code %s, want %s", test.path, chainCode, test.chainCode)
+		}
+		if private := hex.EncodeToString(key.Key); private != test.key {
+			t.Errorf("%s: got key %s, want %s", test.path, private, test.key)
+		}
+	}
+}
+
+func TestDeriveRejectsPath(t *testing.T) {
+	master, err := NewMasterKey(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"", "0/1", "m/", "m/x", "m/1''", "m/2147483648"} {
+		if _, err := master.Derive(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("%q: got %v, want %v", path, err, ErrInvalidPath)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+// keyLength is the size of a P-256 scalar or coordinate, public keys are
+// encoded as X || Y and signatures as R || S, each part left padded to it
+const keyLength = 32
+
+var (
+	ErrInvalidPublicKey  = errors.New("invalid public key")
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+	ErrInvalidSignature  = errors.New("invalid signature encoding")
+)
+
+func padded(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, keyLength))
+}
+
+func EncodePublicKey(pub *ecdsa.PublicKey) []byte {
+	return append(padded(pub.X), padded(pub.Y)...)
+}
+
+func DecodePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	if len(data) != 2*keyLength {
+		return nil, ErrInvalidPublicKey
+	}
+	curve := elliptic.P256()
+	x := new(big.Int).SetBytes(data[:keyLength])
+	y := new(big.Int).SetBytes(data[keyLength:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, ErrInvalidPublicKey
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func EncodePrivateKey(private *ecdsa.PrivateKey) []byte {
+	return padded(private.D)
+}
+
+func DecodePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(data)
+	if len(data) != keyLength || d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+	private := &ecdsa.PrivateKey{D: d}
+	private.PublicKey.Curve = curve
+	private.PublicKey.X, private.PublicKey.Y = curve.ScalarBaseMult(data)
+	return private, nil
+}
+
+func Sign(private ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, &private, hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(padded(r), padded(s)...), nil
+}
+
+func VerifySignature(pubKey, hash, signature []byte) (bool, error) {
+	pub, err := DecodePublicKey(pubKey)
+	if err != nil {
+		return false, err
+	}
+	if len(signature) != 2*keyLength {
+		return false, ErrInvalidSignature
+	}
+	r := new(big.Int).SetBytes(signature[:keyLength])
+	s := new(big.Int).SetBytes(signature[keyLength:])
+	return ecdsa.Verify(pub, hash, r, s), nil
+}
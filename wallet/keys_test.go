@@ -0,0 +1,133 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// isShort tells whether a coordinate or scalar needs padding to keyLength
+func isShort(n *big.Int) bool {
+	return len(n.Bytes()) < keyLength
+}
+
+// TestKeyRoundTrips encodes and decodes many random keys and signatures,
+// going on until keys and signatures with short parts showed up
+func TestKeyRoundTrips(t *testing.T) {
+	const minKeys, maxKeys = 500, 20000
+	var shortX, shortY, shortD, shortR, shortS int
+	for i := 0; i < maxKeys; i++ {
+		if i >= minKeys && shortX > 0 && shortY > 0 && shortD > 0 && shortR > 0 && shortS > 0 {
+			break
+		}
+		private, public := NewKeyPair()
+		if len(public) != 2*keyLength {
+			t.Fatalf("public key is %d bytes", len(public))
+		}
+		pub, err := DecodePublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pub.X.Cmp(private.X) != 0 || pub.Y.Cmp(private.Y) != 0 {
+			t.Fatalf("public key %x did not round trip", public)
+		}
+		decoded, err := DecodePrivateKey(EncodePrivateKey(&private))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.D.Cmp(private.D) != 0 || !bytes.Equal(EncodePublicKey(&decoded.PublicKey), public) {
+			t.Fatalf("private key of %x did not round trip", public)
+		}
+
+		hash := sha256.Sum256(public)
+		signature, err := Sign(private, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signature) != 2*keyLength {
+			t.Fatalf("signature is %d bytes", len(signature))
+		}
+		valid, err := VerifySignature(public, hash[:], signature)
+		if err != nil || !valid {
+			t.Fatalf("signature %x of %x does not verify: %v", signature, public, err)
+		}
+		hash[0] ^= 1
+		if valid, _ := VerifySignature(public, hash[:], signature); valid {
+			t.Fatalf("signature %x verifies another hash", signature)
+		}
+
+		if isShort(private.X) {
+			shortX++
+		}
+		if isShort(private.Y) {
+			shortY++
+		}
+		if isShort(private.D) {
+			shortD++
+		}
+		if isShort(new(big.Int).SetBytes(signature[:keyLength])) {
+			shortR++
+		}
+		if isShort(new(big.Int).SetBytes(signature[keyLength:])) {
+			shortS++
+		}
+	}
+	if shortX == 0 || shortY == 0 || shortD == 0 || shortR == 0 || shortS == 0 {
+		t.Fatalf("no short part in %d keys: X %d, Y %d, D %d, R %d, S %d", maxKeys, shortX, shortY, shortD, shortR, shortS)
+	}
+}
+
+func TestDecodePublicKeyRejects(t *testing.T) {
+	_, public := NewKeyPair()
+	offCurve := append([]byte{}, public...)
+	offCurve[len(offCurve)-1] ^= 1
+	for name, data := range map[string][]byte{
+		"off curve": offCurve,
+		"zero":      make([]byte, 2*keyLength),
+		"short":     public[:2*keyLength-1],
+		"long":      append(append([]byte{}, public...), 0),
+		"empty":     nil,
+	} {
+		if _, err := DecodePublicKey(data); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%s key: got %v, want %v", name, err, ErrInvalidPublicKey)
+		}
+		if _, err := VerifySignature(data, make([]byte, 32), make([]byte, 2*keyLength)); !errors.Is(err, ErrInvalidPublicKey) {
+			t.Errorf("%s key: VerifySignature returned %v, want %v", name, err, ErrInvalidPublicKey)
+		}
+	}
+}
+
+func TestVerifySignatureRejectsLength(t *testing.T) {
+	private, public := NewKeyPair()
+	hash := sha256.Sum256([]byte("message"))
+	signature, err := Sign(private, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{
+		nil,
+		signature[:2*keyLength-1],
+		append(append([]byte{}, signature...), 0),
+		append([]byte{0}, signature...),
+	} {
+		if _, err := VerifySignature(public, hash[:], data); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%d byte signature: got %v, want %v", len(data), err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestDecodePrivateKeyRejects(t *testing.T) {
+	n := elliptic.P256().Params().N
+	for name, data := range map[string][]byte{
+		"zero":  make([]byte, keyLength),
+		"order": n.FillBytes(make([]byte, keyLength)),
+		"short": make([]byte, keyLength-1),
+	} {
+		if _, err := DecodePrivateKey(data); !errors.Is(err, ErrInvalidPrivateKey) {
+			t.Errorf("%s key: got %v, want %v", name, err, ErrInvalidPrivateKey)
+		}
+	}
+}
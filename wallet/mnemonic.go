@@ -0,0 +1,85 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"math/big"
+	"strings"
+)
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// NewMnemonic draws entropy of the given size, 128 to 256 bits in steps of
+// 32, and encodes it as a BIP-39 phrase
+func NewMnemonic(bits int) (string, error) {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrInvalidMnemonic
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic appends the checksum bits to the entropy and reads the
+// result 11 bits per word
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrInvalidMnemonic
+	}
+	checksumBits := bits / 32
+	hash := sha256.Sum256(entropy)
+	n := new(big.Int).SetBytes(entropy)
+	n.Lsh(n, uint(checksumBits))
+	n.Or(n, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+	words := make([]string, (bits+checksumBits)/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = englishWords[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a phrase and verifies its checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrInvalidMnemonic
+	}
+	n := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, ErrInvalidMnemonic
+		}
+		n.Lsh(n, 11)
+		n.Or(n, big.NewInt(int64(index)))
+	}
+	checksumBits := len(words) / 3
+	checksum := byte(new(big.Int).And(n, big.NewInt(1<<uint(checksumBits)-1)).Int64())
+	n.Rsh(n, uint(checksumBits))
+	entropy := n.FillBytes(make([]byte, checksumBits*4))
+	hash := sha256.Sum256(entropy)
+	if hash[0]>>(8-checksumBits) != checksum {
+		return nil, ErrInvalidMnemonic
+	}
+	return entropy, nil
+}
+
+func ValidateMnemonic(mnemonic string) bool {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// MnemonicToSeed stretches a phrase into the 64 byte seed of the key tree,
+// the passphrase is the optional BIP-39 one, not the wallet file passphrase
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New)
+}
@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestMnemonicVectors checks the TREZOR reference vectors of BIP-39, all
+// with the passphrase TREZOR
+func TestMnemonicVectors(t *testing.T) {
+	for _, test := range []struct {
+		entropy, mnemonic, seed string
+	}{
+		{
+			"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+		{
+			"ffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+		},
+		{
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			strings.Repeat("abandon ", 23) + "art",
+			"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+		},
+	} {
+		entropy, _ := hex.DecodeString(test.entropy)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != test.mnemonic {
+			t.Errorf("entropy %s: got %q, want %q", test.entropy, mnemonic, test.mnemonic)
+		}
+		decoded, err := MnemonicToEntropy(test.mnemonic)
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Errorf("%q decoded to %x, %v", test.mnemonic, decoded, err)
+		}
+		if seed := hex.EncodeToString(MnemonicToSeed(test.mnemonic, "TREZOR")); seed != test.seed {
+			t.Errorf("%q: got seed %s, want %s", test.mnemonic, seed, test.seed)
+		}
+	}
+}
+
+func TestMnemonicRejects(t *testing.T) {
+	for name, mnemonic := range map[string]string{
+		"checksum": strings.Repeat("abandon ", 11) + "abandon",
+		"word":     strings.Repeat("abandon ", 11) + "aboutt",
+		"length":   strings.Repeat("abandon ", 10) + "about",
+		"empty":    "",
+	} {
+		if _, err := MnemonicToEntropy(mnemonic); !errors.Is(err, ErrInvalidMnemonic) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidMnemonic)
+		}
+	}
+}
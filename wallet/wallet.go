@@ -6,13 +6,25 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"golang.org/x/crypto/ripemd160"
 	"log"
 )
 
 const (
 	checksumLength = 4
-	version        = byte(0x00)
+	// PubKeyHashLength is the size of the hash an address encodes
+	PubKeyHashLength = ripemd160.Size
+	// MainNetVersion is the version byte of main network addresses, other
+	// networks define their own
+	MainNetVersion = byte(0x00)
+	// keyVersion starts exported private keys
+	keyVersion = byte(0x80)
+)
+
+var (
+	ErrInvalidAddress = errors.New("invalid address")
+	ErrWrongNetwork   = errors.New("address belongs to another network")
 )
 
 type Wallet struct {
@@ -20,22 +32,80 @@ type Wallet struct {
 	PublicKey  []byte
 }
 
+// Address is the main network address of the wallet
 func (w Wallet) Address() []byte {
-	pubHash := PublicKeyHash(w.PublicKey)
-	versionHashed := append([]byte{version}, pubHash...)
+	return w.NetworkAddress(MainNetVersion)
+}
+
+func (w Wallet) NetworkAddress(version byte) []byte {
+	return encodeAddress(version, PublicKeyHash(w.PublicKey))
+}
+
+func encodeAddress(version byte, pubKeyHash []byte) []byte {
+	versionHashed := append([]byte{version}, pubKeyHash...)
 	checksum := Checksum(versionHashed)
 	fullHash := append(versionHashed, checksum...)
 	address := Base58Encode(fullHash)
 	return address
 }
 
-func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
+func decodeAddress(address string) (byte, []byte, error) {
+	pubKeyHash, err := Base58Decode([]byte(address))
+	if err != nil || len(pubKeyHash) != 1+PubKeyHashLength+checksumLength {
+		return 0, nil, ErrInvalidAddress
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
 	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	if !bytes.Equal(actualChecksum, targetChecksum) {
+		return 0, nil, ErrInvalidAddress
+	}
+	return version, pubKeyHash, nil
+}
+
+// CheckAddress tells apart malformed addresses from addresses of another
+// network than the one of version
+func CheckAddress(address string, version byte) error {
+	actual, _, err := decodeAddress(address)
+	if err != nil {
+		return err
+	}
+	if actual != version {
+		return ErrWrongNetwork
+	}
+	return nil
+}
+
+func ValidateAddress(address string, version byte) bool {
+	return CheckAddress(address, version) == nil
+}
+
+// AddressVersion is the network version byte of a well formed address
+func AddressVersion(address string) (byte, error) {
+	version, _, err := decodeAddress(address)
+	return version, err
+}
+
+// ConvertAddress writes an address again for the network of version
+func ConvertAddress(address string, version byte) (string, error) {
+	_, pubKeyHash, err := decodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return string(encodeAddress(version, pubKeyHash)), nil
+}
+
+// PubKeyHashAddress writes the address of a public key hash for the network
+// of version
+func PubKeyHashAddress(pubKeyHash []byte, version byte) string {
+	return string(encodeAddress(version, pubKeyHash))
+}
+
+// AddressPubKeyHash decodes a well formed address of any network
+func AddressPubKeyHash(address string) ([]byte, error) {
+	_, pubKeyHash, err := decodeAddress(address)
+	return pubKeyHash, err
 }
 
 func NewKeyPair() (ecdsa.PrivateKey, []byte) {
@@ -44,8 +114,7 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pub
+	return *private, EncodePublicKey(&private.PublicKey)
 }
 
 func MakeWallet() *Wallet {
@@ -54,6 +123,22 @@ func MakeWallet() *Wallet {
 	return &wallet
 }
 
+// GobEncode stores only the private scalar, the public key is derived again
+// on decode
+func (w Wallet) GobEncode() ([]byte, error) {
+	return EncodePrivateKey(&w.PrivateKey), nil
+}
+
+func (w *Wallet) GobDecode(data []byte) error {
+	private, err := DecodePrivateKey(data)
+	if err != nil {
+		return err
+	}
+	w.PrivateKey = *private
+	w.PublicKey = EncodePublicKey(&private.PublicKey)
+	return nil
+}
+
 func PublicKeyHash(pubKey []byte) []byte {
 	pubHash := sha256.Sum256(pubKey)
 	hasher := ripemd160.New()
@@ -65,6 +150,30 @@ func PublicKeyHash(pubKey []byte) []byte {
 	return pubRipMD
 }
 
+// ExportKey encodes the private key WIF style, the version byte, the key and
+// a checksum in base58
+func (w Wallet) ExportKey() string {
+	payload := append([]byte{keyVersion}, EncodePrivateKey(&w.PrivateKey)...)
+	return string(Base58Encode(append(payload, Checksum(payload)...)))
+}
+
+// ImportKey decodes a key written by ExportKey back into its wallet
+func ImportKey(encoded string) (*Wallet, error) {
+	data, err := Base58Decode([]byte(encoded))
+	if err != nil || len(data) != 1+keyLength+checksumLength || data[0] != keyVersion {
+		return nil, ErrInvalidPrivateKey
+	}
+	payload := data[:len(data)-checksumLength]
+	if !bytes.Equal(Checksum(payload), data[len(data)-checksumLength:]) {
+		return nil, ErrInvalidPrivateKey
+	}
+	private, err := DecodePrivateKey(payload[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &Wallet{*private, EncodePublicKey(&private.PublicKey)}, nil
+}
+
 func Checksum(payload []byte) []byte {
 	firstHash := sha256.Sum256(payload)
 	secondHash := sha256.Sum256(firstHash[:])
@@ -0,0 +1,81 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckAddressHashLength(t *testing.T) {
+	hash := make([]byte, PubKeyHashLength+1)
+	for _, n := range []int{0, 1, PubKeyHashLength - 1, PubKeyHashLength + 1} {
+		address := string(encodeAddress(MainNetVersion, hash[:n]))
+		if err := CheckAddress(address, MainNetVersion); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("%d byte hash: got %v, want %v", n, err, ErrInvalidAddress)
+		}
+	}
+	address := string(encodeAddress(MainNetVersion, hash[:PubKeyHashLength]))
+	if err := CheckAddress(address, MainNetVersion); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportKeyRoundTrip(t *testing.T) {
+	w := MakeWallet()
+	exported := w.ExportKey()
+	imported, err := ImportKey(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.PrivateKey.D.Cmp(w.PrivateKey.D) != 0 || string(imported.PublicKey) != string(w.PublicKey) {
+		t.Fatalf("%s did not round trip", exported)
+	}
+
+	corrupt := []byte(exported)
+	corrupt[len(corrupt)-1] ^= 1
+	for name, key := range map[string]string{
+		"checksum": string(corrupt),
+		"short":    exported[:len(exported)-1],
+		"address":  string(w.Address()),
+		"empty":    "",
+	} {
+		if _, err := ImportKey(key); !errors.Is(err, ErrInvalidPrivateKey) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidPrivateKey)
+		}
+	}
+}
+
+// TestImportWalletSaved imports a key into a wallet file and reads it back
+func TestImportWalletSaved(t *testing.T) {
+	const testVersion = byte(0x6f)
+	dir := t.TempDir()
+	wallets, _ := CreateWallets(dir, testVersion)
+	w := MakeWallet()
+	address, err := wallets.ImportWallet(w.ExportKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != string(w.NetworkAddress(testVersion)) {
+		t.Fatalf("imported as %s, want %s", address, w.NetworkAddress(testVersion))
+	}
+	if err := wallets.SetPassphrase("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := CreateWallets(dir, testVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Unlock("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loaded.GetWallets(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ExportKey() != w.ExportKey() {
+		t.Fatalf("%s came back with another key", address)
+	}
+}
@@ -2,32 +2,75 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-const walletFile = "tmp/wallets.data"
+const walletFile = "wallets.data"
 
+var (
+	ErrWalletNotFound = errors.New("wallet not found")
+	ErrWalletLocked   = errors.New("wallet file is locked")
+	ErrNoPassphrase   = errors.New("wallet file has no passphrase")
+	ErrSeedExists     = errors.New("wallet file already has a recovery phrase")
+)
+
+// Wallets is the wallet file of a data directory. An encrypted file loads
+// locked, only its addresses are known until Unlock decrypts the keys
 type Wallets struct {
 	Wallets map[string]*Wallet
+	// Mnemonic is the recovery phrase addresses are derived from, NextIndex
+	// the index of the next one under DefaultAccountPath
+	Mnemonic  string
+	NextIndex int
+	file      string
+	// version is the address version of the network the file belongs to
+	version byte
+
+	sealed     *sealedWallets
+	passphrase string
+	locked     bool
 }
 
-func CreateWallets() (*Wallets, error) {
+// CreateWallets loads the wallet file kept in dir, the returned Wallets is
+// usable even when the file does not exist yet. Addresses are written with
+// the version of the network the file belongs to
+func CreateWallets(dir string, version byte) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.file = filepath.Join(dir, walletFile)
+	wallets.version = version
 	err := wallets.LoadFile()
 	return &wallets, err
 }
 
-func (ws *Wallets) GetWallets(address string) Wallet {
-	return *ws.Wallets[address]
+func (ws *Wallets) GetWallets(address string) (Wallet, error) {
+	if ws.locked {
+		return Wallet{}, ErrWalletLocked
+	}
+	w, ok := ws.Wallets[address]
+	if !ok {
+		return Wallet{}, ErrWalletNotFound
+	}
+	return *w, nil
 }
 
 func (ws *Wallets) GetAllWallets() []string {
+	if ws.locked {
+		var addresses []string
+		for _, address := range ws.sealed.Addresses {
+			if converted, err := ConvertAddress(address, ws.version); err == nil {
+				addresses = append(addresses, converted)
+			}
+		}
+		return addresses
+	}
 	var addresses []string
 	for address := range ws.Wallets {
 		addresses = append(addresses, address)
@@ -35,42 +78,241 @@ func (ws *Wallets) GetAllWallets() []string {
 	return addresses
 }
 
-func (ws *Wallets) AddWallet() string {
+// AddWallet derives the next address from the recovery phrase, files
+// without one get a random key
+func (ws *Wallets) AddWallet() (string, error) {
+	if ws.locked {
+		return "", ErrWalletLocked
+	}
 	wallet := MakeWallet()
-	address := fmt.Sprintf("%s", wallet.Address())
+	if ws.HasSeed() {
+		var err error
+		if wallet, err = ws.derive(ws.NextIndex); err != nil {
+			return "", err
+		}
+		ws.NextIndex++
+	}
+	address := ws.address(wallet)
 	ws.Wallets[address] = wallet
-	return address
+	return address, nil
 }
 
-func (ws *Wallets) LoadFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+// ImportWallet adds the wallet of an exported key, keys from outside the
+// recovery phrase need their own backup
+func (ws *Wallets) ImportWallet(key string) (string, error) {
+	if ws.locked {
+		return "", ErrWalletLocked
+	}
+	wallet, err := ImportKey(key)
+	if err != nil {
+		return "", err
+	}
+	address := ws.address(wallet)
+	ws.Wallets[address] = wallet
+	return address, nil
+}
+
+func (ws *Wallets) address(wallet *Wallet) string {
+	return string(wallet.NetworkAddress(ws.version))
+}
+
+// keyed maps wallets by their address, files written before addresses had
+// network versions used the main network one for every network
+func (ws *Wallets) keyed(wallets map[string]*Wallet) map[string]*Wallet {
+	keyed := make(map[string]*Wallet, len(wallets))
+	for _, wallet := range wallets {
+		keyed[ws.address(wallet)] = wallet
+	}
+	return keyed
+}
+
+func (ws *Wallets) HasSeed() bool {
+	return ws.Mnemonic != ""
+}
+
+// SetMnemonic makes phrase the seed of derived addresses, a file keeps the
+// phrase it was given first
+func (ws *Wallets) SetMnemonic(mnemonic string) error {
+	if ws.locked {
+		return ErrWalletLocked
+	}
+	if !ValidateMnemonic(mnemonic) {
+		return ErrInvalidMnemonic
+	}
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	if ws.HasSeed() && ws.Mnemonic != mnemonic {
+		return ErrSeedExists
+	}
+	ws.Mnemonic = mnemonic
+	return nil
+}
+
+// Restore derives the first count addresses of the recovery phrase again,
+// it returns them in derivation order
+func (ws *Wallets) Restore(count int) ([]string, error) {
+	if ws.locked {
+		return nil, ErrWalletLocked
+	}
+	if !ws.HasSeed() {
+		return nil, ErrInvalidMnemonic
+	}
+	var addresses []string
+	for i := 0; i < count; i++ {
+		wallet, err := ws.derive(i)
+		if err != nil {
+			return nil, err
+		}
+		address := ws.address(wallet)
+		ws.Wallets[address] = wallet
+		addresses = append(addresses, address)
+	}
+	if count > ws.NextIndex {
+		ws.NextIndex = count
+	}
+	return addresses, nil
+}
+
+func (ws *Wallets) derive(index int) (*Wallet, error) {
+	seed := MnemonicToSeed(ws.Mnemonic, "")
+	return DeriveWallet(seed, fmt.Sprintf("%s/%d", DefaultAccountPath, index))
+}
+
+// Encrypted tells whether the file on disk is encrypted, files written before
+// encryption stay plaintext until they are saved with a passphrase
+func (ws *Wallets) Encrypted() bool {
+	return ws.sealed != nil
+}
+
+func (ws *Wallets) Locked() bool {
+	return ws.locked
+}
+
+// Unlock decrypts the keys, on unlocked files it only checks the passphrase
+func (ws *Wallets) Unlock(passphrase string) error {
+	if ws.sealed == nil {
+		return nil
+	}
+	if !ws.locked {
+		if passphrase != ws.passphrase {
+			return ErrWrongPassphrase
+		}
+		return nil
+	}
+	plaintext, err := ws.sealed.open(passphrase)
+	if err != nil {
 		return err
 	}
 	var wallets Wallets
-	fileContet, err := ioutil.ReadFile(walletFile)
+	if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&wallets); err != nil {
+		return err
+	}
+	ws.Wallets = ws.keyed(wallets.Wallets)
+	ws.Mnemonic = wallets.Mnemonic
+	ws.NextIndex = wallets.NextIndex
+	ws.passphrase = passphrase
+	ws.locked = false
+	return nil
+}
+
+// Lock forgets the keys and the passphrase, wallets added since the last
+// SaveFile are lost
+func (ws *Wallets) Lock() error {
+	if ws.sealed == nil {
+		return ErrNoPassphrase
+	}
+	ws.Wallets = make(map[string]*Wallet)
+	ws.Mnemonic = ""
+	ws.NextIndex = 0
+	ws.passphrase = ""
+	ws.locked = true
+	return nil
+}
+
+// SetPassphrase sets the passphrase the next SaveFile encrypts with
+func (ws *Wallets) SetPassphrase(passphrase string) error {
+	if ws.locked {
+		return ErrWalletLocked
+	}
+	if passphrase == "" {
+		return ErrNoPassphrase
+	}
+	ws.passphrase = passphrase
+	return nil
+}
+
+// ChangePassphrase encrypts the file again under a new passphrase
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if err := ws.Unlock(oldPassphrase); err != nil {
+		return err
+	}
+	if err := ws.SetPassphrase(newPassphrase); err != nil {
+		return err
+	}
+	return ws.SaveFile()
+}
+
+func (ws *Wallets) LoadFile() error {
+	if _, err := os.Stat(ws.file); os.IsNotExist(err) {
+		return err
+	}
+	fileContet, err := ioutil.ReadFile(ws.file)
 	if err != nil {
 		return err
 	}
-	gob.Register(elliptic.P256())
+	if bytes.HasPrefix(fileContet, encryptedMagic) {
+		sealed, err := decodeSealed(fileContet)
+		if err != nil {
+			return err
+		}
+		ws.sealed = sealed
+		return ws.Lock()
+	}
+	var wallets Wallets
 	decoder := gob.NewDecoder(bytes.NewReader(fileContet))
 	err = decoder.Decode(&wallets)
 	if err != nil {
 		return err
 	}
-	ws.Wallets = wallets.Wallets
+	ws.Wallets = ws.keyed(wallets.Wallets)
+	ws.Mnemonic = wallets.Mnemonic
+	ws.NextIndex = wallets.NextIndex
 	return nil
 }
 
-func (ws *Wallets) SaveFile() {
+// SaveFile encrypts the wallets under the passphrase and replaces the file,
+// which only its owner can read
+func (ws *Wallets) SaveFile() error {
+	if ws.locked {
+		return ErrWalletLocked
+	}
+	if ws.passphrase == "" {
+		return ErrNoPassphrase
+	}
 	var content bytes.Buffer
-	gob.Register(elliptic.P256())
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	addresses := ws.GetAllWallets()
+	sort.Strings(addresses)
+	sealedContent, err := seal(ws.passphrase, addresses, content.Bytes())
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ws.file), 0755); err != nil {
+		return err
+	}
+	tmp := ws.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, sealedContent, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, ws.file); err != nil {
+		return err
 	}
+	ws.sealed, err = decodeSealed(sealedContent)
+	return err
 }